@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Defaults for the zero fields of AdaptiveConcurrency.
+const (
+	DefaultAdaptiveWindow       = 20
+	DefaultAdaptiveMaxErrorRate = 0.1
+)
+
+// AdaptiveConcurrency configures a limit on the fetches running at once
+// from each host that follows how the host responds, in the manner of
+// TCP congestion control: after a window of healthy fetches the limit
+// goes up by one, and after a window with too many transient failures,
+// or too slow on average, it is halved.
+type AdaptiveConcurrency struct {
+	// Min and Max bound the limit of each host, which starts at Max.
+	// Min below one means one; Max zero means Config.Concurrency, or ten
+	// times DefaultConcurrency if that is unlimited.
+	Min, Max int
+	// Window is the number of fetches from a host judged together.
+	// Zero means DefaultAdaptiveWindow.
+	Window int
+	// MaxErrorRate is the share of the fetches in a window that may fail
+	// transiently, by timing out or with a 5xx or 429 response, before
+	// the limit is halved. Zero means DefaultAdaptiveMaxErrorRate.
+	MaxErrorRate float64
+	// SlowLatency, if positive, halves the limit when the fetches in a
+	// window take longer than it on average.
+	SlowLatency time.Duration
+}
+
+// AdaptiveLimit bounds the number of fetches running at once from each
+// host by a limit an AdaptiveConcurrency adjusts from the outcomes it is
+// told of. Hosts are told apart by the host and port of the URLs, and
+// one backing off doesn't hold back the others. A nil AdaptiveLimit
+// imposes no limit.
+type AdaptiveLimit struct {
+	cfg    AdaptiveConcurrency
+	logger *slog.Logger
+
+	mux   sync.Mutex
+	hosts map[string]*hostLimit
+}
+
+// hostLimit is the state of one host of an AdaptiveLimit.
+type hostLimit struct {
+	limit int
+	held  int
+	// changed is closed, and replaced, whenever a slot frees or the
+	// limit goes up, waking the Acquire calls waiting for one.
+	changed chan struct{}
+	// samples is a ring of the latest outcomes, next the index of the
+	// oldest and fresh the number taken since the limit last changed.
+	samples []adaptiveSample
+	next    int
+	fresh   int
+}
+
+type adaptiveSample struct {
+	failed bool
+	took   time.Duration
+}
+
+// NewAdaptiveLimit returns an AdaptiveLimit configured by cfg, with
+// concurrency the fixed limit its zero Max stands for, logging the
+// changes of the limits to logger.
+func NewAdaptiveLimit(cfg AdaptiveConcurrency, concurrency int, logger *slog.Logger) *AdaptiveLimit {
+	if cfg.Max <= 0 {
+		cfg.Max = concurrency
+		if cfg.Max <= 0 {
+			cfg.Max = 10 * DefaultConcurrency
+		}
+	}
+	cfg.Min = min(max(cfg.Min, 1), cfg.Max)
+	if cfg.Window <= 0 {
+		cfg.Window = DefaultAdaptiveWindow
+	}
+	if cfg.MaxErrorRate <= 0 {
+		cfg.MaxErrorRate = DefaultAdaptiveMaxErrorRate
+	}
+	return &AdaptiveLimit{
+		cfg:    cfg,
+		logger: orDiscard(logger),
+		hosts:  make(map[string]*hostLimit),
+	}
+}
+
+// host returns the state of host, creating it on first use. l.mux must
+// be held.
+func (l *AdaptiveLimit) host(host string) *hostLimit {
+	h, ok := l.hosts[host]
+	if !ok {
+		h = &hostLimit{
+			limit:   l.cfg.Max,
+			changed: make(chan struct{}),
+			samples: make([]adaptiveSample, l.cfg.Window),
+		}
+		l.hosts[host] = h
+	}
+	return h
+}
+
+// Limit returns the number of fetches from the host of url currently
+// allowed at once.
+func (l *AdaptiveLimit) Limit(url string) int {
+	if l == nil {
+		return 0
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.host(entryHost(url)).limit
+}
+
+// Acquire blocks until a slot for the host of url is free under its
+// current limit or ctx is done.
+func (l *AdaptiveLimit) Acquire(ctx context.Context, url string) error {
+	if l == nil {
+		return ctx.Err()
+	}
+	host := entryHost(url)
+	for {
+		l.mux.Lock()
+		h := l.host(host)
+		if h.held < h.limit {
+			h.held++
+			l.mux.Unlock()
+			return nil
+		}
+		changed := h.changed
+		l.mux.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Release frees the slot for the host of url taken by Acquire.
+func (l *AdaptiveLimit) Release(url string) {
+	if l == nil {
+		return
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	h := l.host(entryHost(url))
+	h.held--
+	h.wake()
+}
+
+// wake signals the Acquire calls waiting for h. The AdaptiveLimit's
+// mux must be held.
+func (h *hostLimit) wake() {
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+// Observe records the outcome of a fetch of url that took took,
+// adjusting the limit of its host once a window of fetches from it has
+// been seen since the limit last changed. Only fetches that went to the
+// host tell of it: those served from a cache, or cut short by their
+// context being done, should not be observed.
+func (l *AdaptiveLimit) Observe(url string, err error, took time.Duration) {
+	if l == nil {
+		return
+	}
+	host := entryHost(url)
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	h := l.host(host)
+	h.samples[h.next] = adaptiveSample{failed: err != nil && isRetryable(err), took: took}
+	h.next = (h.next + 1) % len(h.samples)
+	h.fresh++
+	if h.fresh < len(h.samples) {
+		return
+	}
+	var failed int
+	var total time.Duration
+	for _, s := range h.samples {
+		if s.failed {
+			failed++
+		}
+		total += s.took
+	}
+	errorRate := float64(failed) / float64(len(h.samples))
+	average := total / time.Duration(len(h.samples))
+	slow := l.cfg.SlowLatency > 0 && average > l.cfg.SlowLatency
+	switch {
+	case errorRate > l.cfg.MaxErrorRate || slow:
+		if h.limit > l.cfg.Min {
+			h.limit = max(h.limit/2, l.cfg.Min)
+			l.logger.Info("concurrency lowered", "host", host, "limit", h.limit, "error_rate", errorRate, "average", average)
+		}
+	case h.limit < l.cfg.Max:
+		h.limit++
+		l.logger.Debug("concurrency raised", "host", host, "limit", h.limit)
+		h.wake()
+	}
+	// The next decision waits for a window of fetches made under the
+	// new limit.
+	h.fresh = 0
+}
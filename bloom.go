@@ -0,0 +1,58 @@
+package main
+
+import (
+	"hash/maphash"
+	"math"
+	"sync"
+)
+
+// BloomVisitedSet is a VisitedSet backed by a Bloom filter, for crawls
+// too large to keep every URL in memory. It uses a fixed number of bits
+// however many URLs are added, at the price of false positives: now and
+// then an unvisited URL is taken for a visited one and its page is never
+// crawled. It does not remember depths, so a page first reached by a
+// long path is not crawled again from a shorter one. URLs are compared
+// in their normalized form.
+type BloomVisitedSet struct {
+	bits   []uint64
+	hashes int
+	seeds  [2]maphash.Seed
+	mux    sync.Mutex
+}
+
+// NewBloomVisitedSet returns a BloomVisitedSet sized to hold expected
+// URLs with a false-positive rate of about rate. Adding more URLs than
+// expected raises the rate.
+func NewBloomVisitedSet(expected int, rate float64) *BloomVisitedSet {
+	expected = max(expected, 1)
+	rate = min(max(rate, 1e-9), 0.5)
+	bits := math.Ceil(-float64(expected) * math.Log(rate) / (math.Ln2 * math.Ln2))
+	hashes := max(int(math.Round(bits/float64(expected)*math.Ln2)), 1)
+	return &BloomVisitedSet{
+		bits:   make([]uint64, (int(bits)+63)/64),
+		hashes: hashes,
+		seeds:  [2]maphash.Seed{maphash.MakeSeed(), maphash.MakeSeed()},
+	}
+}
+
+// Visit implements VisitedSet, reporting crawl for URLs the filter has
+// not seen. It never reports a revisit.
+func (s *BloomVisitedSet) Visit(url string, depth int) (crawl, revisit bool) {
+	key := urlKey(url)
+	// Double hashing derives all the bit positions from two hashes.
+	h1 := maphash.String(s.seeds[0], key)
+	h2 := maphash.String(s.seeds[1], key) | 1
+	n := uint64(len(s.bits) * 64)
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for i := range s.hashes {
+		bit := (h1 + uint64(i)*h2) % n
+		word, mask := bit/64, uint64(1)<<(bit%64)
+		if s.bits[word]&mask == 0 {
+			crawl = true
+			s.bits[word] |= mask
+		}
+	}
+	return crawl, false
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestBloomVisitedSetFalsePositiveRate(t *testing.T) {
+	const expected, probes = 10000, 20000
+	for _, rate := range []float64{0.1, 0.01, 0.001} {
+		t.Run(fmt.Sprint(rate), func(t *testing.T) {
+			set := NewBloomVisitedSet(expected, rate)
+			for i := range expected {
+				set.Visit(fmt.Sprintf("https://example.com/added/%d", i), 0)
+			}
+			for i := range expected {
+				url := fmt.Sprintf("https://example.com/added/%d", i)
+				if crawl, _ := set.Visit(url, 0); crawl {
+					t.Fatalf("Visit(%q) after adding it reported crawl", url)
+				}
+			}
+			// Visit adds the URLs it is asked about, so the bits are
+			// put back after each probe to keep the filter at the
+			// expected size.
+			added := slices.Clone(set.bits)
+			var falsePositives int
+			for i := range probes {
+				if crawl, _ := set.Visit(fmt.Sprintf("https://example.com/probed/%d", i), 0); crawl {
+					copy(set.bits, added)
+				} else {
+					falsePositives++
+				}
+			}
+			// The seeds are random; twice the rate leaves a margin of
+			// several standard deviations at these sizes.
+			if got := float64(falsePositives) / probes; got > 2*rate {
+				t.Errorf("false-positive rate = %.4f, want at most about %v", got, rate)
+			}
+		})
+	}
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+type CacheItem struct {
+	body      string
+	urls      []string
+	fetchedAt time.Time
+	// finalURL is where the page was served from, if it was redirected.
+	finalURL   string
+	statusCode int
+	truncated  bool
+	canonical  string
+	noindex    bool
+	nofollow   bool
+	headers    map[string]string
+	// etag and lastModified revalidate the page once it expires.
+	etag         string
+	lastModified string
+	// loaded is set for the pages read by LoadFromFile until they are
+	// fetched again.
+	loaded bool
+}
+
+// cacheEntry is the value stored in CacheFetcher's recency list.
+type cacheEntry struct {
+	url  string
+	item CacheItem
+}
+
+type CacheFetcher struct {
+	// TTL is how long a cached page stays fresh. Expired entries are
+	// fetched again and overwritten, with a conditional request if they
+	// have an ETag or Last-Modified: if the fetcher fails it with
+	// ErrNotModified, the entry is served as a cache hit and is fresh
+	// again. Zero means entries never expire.
+	// It must be set before the first call to Fetch.
+	TTL time.Duration
+	// Incremental treats the pages read by LoadFromFile as expired,
+	// however fresh, so that a crawl from a saved cache asks the server
+	// for each of them once, revalidating those it can and downloading
+	// again only the pages that changed. A page found the same, by a
+	// not-modified response or an identical body, is returned with
+	// Unchanged set, and the pages found the same and changed are
+	// counted in the Stats.
+	Incremental bool
+	// Stats, if set, counts cache hits and misses.
+	Stats *StatsCollector
+	// Logger, if set, receives a debug message for every cache hit,
+	// with the request ID of the fetch if it has one.
+	Logger *slog.Logger
+
+	// shards hold the cached pages, each URL in the shard picked by
+	// hashing it, so that concurrent fetches rarely wait on one lock.
+	shards []*cacheShard
+	seed   maphash.Seed
+	// group collapses concurrent fetches of the same URL.
+	group   singleflight.Group
+	fetcher Fetcher
+}
+
+// cacheShard is one lock's worth of a CacheFetcher's pages.
+type cacheShard struct {
+	// maxEntries bounds the number of pages in the shard; once exceeded
+	// the least recently used page is evicted. Zero means no bound.
+	maxEntries int
+	items      map[string]*list.Element
+	// recency orders entries from most (front) to least recently used.
+	recency *list.List
+	mux     sync.Mutex
+}
+
+// DefaultCacheShards is a number of shards for NewShardedCacheFetcher
+// that keeps lock contention low on most machines.
+const DefaultCacheShards = 16
+
+func (f *CacheFetcher) Fetch(url string) (string, []string, error) {
+	page, err := f.FetchContext(context.Background(), url)
+	if err != nil {
+		return "", nil, err
+	}
+	return page.Body, page.Links, nil
+}
+
+func (f *CacheFetcher) FetchPage(url string) (*Page, error) {
+	return f.FetchContext(context.Background(), url)
+}
+
+// FetchContext returns the cached page for url if it is fresh and
+// fetches it otherwise. Pages are cached under their normalized URL.
+// Concurrent callers for a URL that isn't cached share a single fetch,
+// made with the first caller's ctx, and its result, error included.
+// Once ctx is done FetchContext returns ctx.Err(), even for cached pages,
+// so a cancelled crawl stops finding links in the cache.
+func (f *CacheFetcher) FetchContext(ctx context.Context, url string) (*Page, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	key := urlKey(url)
+	if page, ok := f.lookup(ctx, key, url); ok {
+		f.Stats.cacheHit()
+		return page, nil
+	}
+
+	leader := false
+	ch := f.group.DoChan(key, func() (any, error) {
+		leader = true
+		// A fetch that completed just before we got here has
+		// already filled the cache.
+		if page, ok := f.lookup(ctx, key, url); ok {
+			return page, nil
+		}
+		stale, cached := f.stale(key)
+		revalidate := cached && (stale.etag != "" || stale.lastModified != "")
+		// Only the first fetch of a loaded page compares it with the
+		// last crawl.
+		compare := f.Incremental && cached && stale.loaded
+		fetchCtx := ctx
+		if revalidate {
+			fetchCtx = WithValidators(ctx, Validators{URL: url, ETag: stale.etag, LastModified: stale.lastModified})
+		}
+		page, err := fetchContext(fetchCtx, f.fetcher, url)
+		if revalidate && errors.Is(err, ErrNotModified) {
+			f.Stats.cacheHit()
+			fetchLogger(ctx, f.Logger).Debug("revalidated in cache", "url", url)
+			stale.fetchedAt = time.Now()
+			stale.loaded = false
+			shard := f.shard(key)
+			shard.mux.Lock()
+			shard.put(key, stale)
+			shard.mux.Unlock()
+			page := stale.page(url)
+			if compare {
+				page.Unchanged = true
+				f.Stats.pageCompared(true)
+			}
+			return page, nil
+		}
+		f.Stats.cacheMiss()
+		if err != nil {
+			return nil, err
+		}
+		if compare {
+			page.Unchanged = page.Body == stale.body
+			f.Stats.pageCompared(page.Unchanged)
+		}
+		item := CacheItem{
+			body:         page.Body,
+			urls:         page.Links,
+			fetchedAt:    time.Now(),
+			statusCode:   page.StatusCode,
+			truncated:    page.Truncated,
+			canonical:    page.Canonical,
+			noindex:      page.NoIndex,
+			nofollow:     page.NoFollow,
+			headers:      page.Headers,
+			etag:         page.ETag,
+			lastModified: page.LastModified,
+		}
+		if page.URL != url {
+			item.finalURL = page.URL
+		}
+		shard := f.shard(key)
+		shard.mux.Lock()
+		shard.put(key, item)
+		shard.mux.Unlock()
+		return page, nil
+	})
+	var res singleflight.Result
+	select {
+	case res = <-ch:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	page := *res.Val.(*Page)
+	if !leader {
+		f.Stats.cacheHit()
+		page.Cached = true
+		// Unless it was redirected, report the page under the
+		// caller's spelling of the URL rather than the leader's.
+		if urlKey(page.URL) == key {
+			page.URL = url
+		}
+	}
+	return &page, nil
+}
+
+// Close closes the wrapped fetcher.
+func (f *CacheFetcher) Close() error {
+	return closeFetcher(f.fetcher)
+}
+
+// Len returns the number of pages cached.
+func (f *CacheFetcher) Len() int {
+	n := 0
+	for _, shard := range f.shards {
+		shard.mux.Lock()
+		n += shard.recency.Len()
+		shard.mux.Unlock()
+	}
+	return n
+}
+
+// lookup returns the fresh cached page for key, reported under url.
+func (f *CacheFetcher) lookup(ctx context.Context, key, url string) (*Page, bool) {
+	shard := f.shard(key)
+	shard.mux.Lock()
+	item, ok := shard.get(key)
+	shard.mux.Unlock()
+	if !ok || f.expired(item) {
+		return nil, false
+	}
+	fetchLogger(ctx, f.Logger).Debug("hit from cache", "url", url)
+	page := item.page(url)
+	page.Cached = true
+	return page, true
+}
+
+// stale returns the expired item cached for key, if any.
+func (f *CacheFetcher) stale(key string) (CacheItem, bool) {
+	shard := f.shard(key)
+	shard.mux.Lock()
+	defer shard.mux.Unlock()
+	return shard.get(key)
+}
+
+// page returns item as the Page fetched for url.
+func (item CacheItem) page(url string) *Page {
+	if item.finalURL != "" {
+		url = item.finalURL
+	}
+	return &Page{
+		URL:          url,
+		Body:         item.body,
+		Links:        item.urls,
+		StatusCode:   item.statusCode,
+		Truncated:    item.truncated,
+		Canonical:    item.canonical,
+		NoIndex:      item.noindex,
+		NoFollow:     item.nofollow,
+		Headers:      item.headers,
+		ETag:         item.etag,
+		LastModified: item.lastModified,
+	}
+}
+
+// shard returns the shard holding key.
+func (f *CacheFetcher) shard(key string) *cacheShard {
+	return f.shards[maphash.String(f.seed, key)%uint64(len(f.shards))]
+}
+
+// get returns the item cached for url and marks it most recently used.
+// s.mux must be held.
+func (s *cacheShard) get(url string) (CacheItem, bool) {
+	elem, ok := s.items[url]
+	if !ok {
+		return CacheItem{}, false
+	}
+	s.recency.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).item, true
+}
+
+// put stores item for url as the most recently used entry, evicting the
+// least recently used ones beyond maxEntries. s.mux must be held.
+func (s *cacheShard) put(url string, item CacheItem) {
+	if elem, ok := s.items[url]; ok {
+		elem.Value.(*cacheEntry).item = item
+		s.recency.MoveToFront(elem)
+		return
+	}
+	s.items[url] = s.recency.PushFront(&cacheEntry{url, item})
+	for s.maxEntries > 0 && s.recency.Len() > s.maxEntries {
+		oldest := s.recency.Back()
+		s.recency.Remove(oldest)
+		delete(s.items, oldest.Value.(*cacheEntry).url)
+	}
+}
+
+// expired reports whether item is older than the cache TTL, or was
+// loaded from a file and is yet to be fetched again by an incremental
+// cache.
+func (f *CacheFetcher) expired(item CacheItem) bool {
+	if f.Incremental && item.loaded {
+		return true
+	}
+	return f.TTL > 0 && time.Since(item.fetchedAt) > f.TTL
+}
+
+// NewCacheFetcher returns a CacheFetcher in front of fetcher, holding
+// every page it fetches.
+func NewCacheFetcher(fetcher Fetcher) *CacheFetcher {
+	return NewBoundedCacheFetcher(fetcher, 0)
+}
+
+// DefaultCacheEntries is the number of pages cached by the fetcher a
+// Crawler creates when Config.Fetcher is nil, and by the command unless
+// -cache-size says otherwise.
+const DefaultCacheEntries = 10000
+
+// NewBoundedCacheFetcher returns a CacheFetcher holding at most
+// maxEntries pages, evicting the least recently used page when full.
+// A maxEntries of zero or less means no bound.
+func NewBoundedCacheFetcher(fetcher Fetcher, maxEntries int) *CacheFetcher {
+	return NewShardedCacheFetcher(fetcher, maxEntries, 1)
+}
+
+// NewShardedCacheFetcher is like NewBoundedCacheFetcher but spreads the
+// pages over the given number of shards, each with its own lock, for
+// caches shared by many concurrent fetches. The
+// bound is split between the shards, adding up to maxEntries, and each
+// evicts its own least recently used pages, so eviction order is only
+// approximately LRU across the whole cache. A shards of one or less
+// gives a single lock, and there are never more shards than maxEntries.
+func NewShardedCacheFetcher(fetcher Fetcher, maxEntries, shards int) *CacheFetcher {
+	shards = max(shards, 1)
+	if maxEntries > 0 {
+		shards = min(shards, maxEntries)
+	}
+	all := make([]*cacheShard, shards)
+	for i := range all {
+		perShard := 0
+		if maxEntries > 0 {
+			// The first shards take the remainder of the split.
+			perShard = maxEntries / shards
+			if i < maxEntries%shards {
+				perShard++
+			}
+		}
+		all[i] = &cacheShard{
+			maxEntries: perShard,
+			items:      make(map[string]*list.Element),
+			recency:    list.New(),
+		}
+	}
+	return &CacheFetcher{
+		shards:  all,
+		seed:    maphash.MakeSeed(),
+		fetcher: fetcher,
+	}
+}
+
+// cacheFileEntry is the on-disk form of a CacheItem.
+type cacheFileEntry struct {
+	Body         string            `json:"body"`
+	URLs         []string          `json:"urls"`
+	FetchedAt    time.Time         `json:"fetched_at"`
+	FinalURL     string            `json:"final_url,omitempty"`
+	StatusCode   int               `json:"status,omitempty"`
+	Truncated    bool              `json:"truncated,omitempty"`
+	Canonical    string            `json:"canonical,omitempty"`
+	NoIndex      bool              `json:"noindex,omitempty"`
+	NoFollow     bool              `json:"nofollow,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	ETag         string            `json:"etag,omitempty"`
+	LastModified string            `json:"last_modified,omitempty"`
+}
+
+// SaveToFile writes the cached pages to path as JSON.
+func (f *CacheFetcher) SaveToFile(path string) error {
+	entries := make(map[string]cacheFileEntry)
+	for _, shard := range f.shards {
+		shard.mux.Lock()
+		for url, elem := range shard.items {
+			item := elem.Value.(*cacheEntry).item
+			entries[url] = cacheFileEntry{
+				Body:         item.body,
+				URLs:         item.urls,
+				FetchedAt:    item.fetchedAt,
+				FinalURL:     item.finalURL,
+				StatusCode:   item.statusCode,
+				Truncated:    item.truncated,
+				Canonical:    item.canonical,
+				NoIndex:      item.noindex,
+				NoFollow:     item.nofollow,
+				Headers:      item.headers,
+				ETag:         item.etag,
+				LastModified: item.lastModified,
+			}
+		}
+		shard.mux.Unlock()
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// LoadFromFile adds the pages saved by SaveToFile at path to the cache,
+// replacing any entries already cached for the same URLs. A missing file
+// leaves the cache unchanged.
+func (f *CacheFetcher) LoadFromFile(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var entries map[string]cacheFileEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("load cache %s: %w", path, err)
+	}
+
+	for url, e := range entries {
+		key := urlKey(url)
+		shard := f.shard(key)
+		shard.mux.Lock()
+		shard.put(key, CacheItem{
+			body:         e.Body,
+			urls:         e.URLs,
+			fetchedAt:    e.FetchedAt,
+			finalURL:     e.FinalURL,
+			statusCode:   e.StatusCode,
+			truncated:    e.Truncated,
+			canonical:    e.Canonical,
+			noindex:      e.NoIndex,
+			nofollow:     e.NoFollow,
+			headers:      e.Headers,
+			etag:         e.ETag,
+			lastModified: e.LastModified,
+			loaded:       true,
+		})
+		shard.mux.Unlock()
+	}
+	return nil
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingFetcher wraps a Fetcher, counting calls and delaying each one
+// so that concurrent callers overlap.
+type countingFetcher struct {
+	calls   atomic.Int64
+	delay   time.Duration
+	fetcher Fetcher
+}
+
+func (f *countingFetcher) Fetch(url string) (string, []string, error) {
+	f.calls.Add(1)
+	time.Sleep(f.delay)
+	return f.fetcher.Fetch(url)
+}
+
+func TestCacheFetcherConcurrentSameURL(t *testing.T) {
+	counter := &countingFetcher{delay: 10 * time.Millisecond, fetcher: fetcher}
+	cache := NewCacheFetcher(counter)
+
+	const goroutines = 50
+	var wg sync.WaitGroup
+	for range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body, _, err := cache.Fetch("https://golang.org/")
+			if err != nil {
+				t.Errorf("Fetch: %v", err)
+			} else if body != "The Go Programming Language" {
+				t.Errorf("Fetch body = %q", body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := counter.calls.Load(); got != 1 {
+		t.Errorf("underlying fetcher called %d times, want 1", got)
+	}
+}
+
+func TestCacheFetcherConcurrentDistinctURLs(t *testing.T) {
+	counter := &countingFetcher{delay: time.Millisecond, fetcher: fetcher}
+	cache := NewCacheFetcher(counter)
+
+	var wg sync.WaitGroup
+	for range 10 {
+		for url := range fetcher {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, _, err := cache.Fetch(url); err != nil {
+					t.Errorf("Fetch(%q): %v", url, err)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	if got, want := counter.calls.Load(), int64(len(fetcher)); got != want {
+		t.Errorf("underlying fetcher called %d times, want %d", got, want)
+	}
+}
+
+func TestCacheFetcherConcurrentErrorShared(t *testing.T) {
+	counter := &countingFetcher{delay: 10 * time.Millisecond, fetcher: fetcher}
+	cache := NewCacheFetcher(counter)
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := cache.Fetch("https://golang.org/cmd/"); err == nil {
+				t.Error("Fetch of missing page succeeded")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := counter.calls.Load(); got != 1 {
+		t.Errorf("underlying fetcher called %d times, want 1", got)
+	}
+}
+
+func TestCacheFetcherDoesNotCacheErrors(t *testing.T) {
+	counter := &countingFetcher{fetcher: fetcher}
+	cache := NewCacheFetcher(counter)
+
+	for range 3 {
+		if _, _, err := cache.Fetch("https://golang.org/cmd/"); err == nil {
+			t.Fatal("Fetch of missing page succeeded")
+		}
+	}
+	if got := counter.calls.Load(); got != 3 {
+		t.Errorf("underlying fetcher called %d times, want 3", got)
+	}
+}
+
+func TestCacheFetcherRevalidates(t *testing.T) {
+	var full, notModified atomic.Int64
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full.Add(1)
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, `<a href="/next">next</a>`)
+	}))
+	defer srv.Close()
+
+	cache := NewCacheFetcher(NewHTTPFetcher(nil))
+	cache.TTL = time.Nanosecond
+	cache.Stats = NewStatsCollector()
+	for range 3 {
+		page, err := cache.FetchPage(srv.URL + "/")
+		if err != nil {
+			t.Fatalf("FetchPage: %v", err)
+		}
+		if want := []string{srv.URL + "/next"}; !slices.Equal(page.Links, want) {
+			t.Errorf("links = %q, want %q from the cached copy", page.Links, want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if full.Load() != 1 || notModified.Load() != 2 {
+		t.Errorf("server sent %d full responses and %d 304s, want 1 and 2", full.Load(), notModified.Load())
+	}
+	if s := cache.Stats.Stats(); s.CacheHits != 2 || s.CacheMisses != 1 {
+		t.Errorf("cache hits, misses = %d, %d; want 2, 1", s.CacheHits, s.CacheMisses)
+	}
+}
+
+// BenchmarkCacheFetcherShards compares a single-lock cache with a sharded
+// one under many goroutines at once, both serving cache hits and missing
+// pages that then evict others from a full cache.
+func BenchmarkCacheFetcherShards(b *testing.B) {
+	const pages, bound = 1 << 14, 1024
+	canned := make(fakeFetcher, pages)
+	urls := make([]string, pages)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("https://example.com/page/%d", i)
+		canned[urls[i]] = &fakeResult{body: urls[i]}
+	}
+	for _, shards := range []int{1, DefaultCacheShards} {
+		b.Run(fmt.Sprintf("hits/shards=%d", shards), func(b *testing.B) {
+			cache := NewShardedCacheFetcher(canned, 0, shards)
+			for _, url := range urls[:bound] {
+				cache.Fetch(url)
+			}
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					if _, _, err := cache.Fetch(urls[i%bound]); err != nil {
+						b.Error(err)
+					}
+					i++
+				}
+			})
+		})
+		b.Run(fmt.Sprintf("misses/shards=%d", shards), func(b *testing.B) {
+			// Cycling through many more pages than the bound, each
+			// fetch misses and puts a page, evicting another.
+			cache := NewShardedCacheFetcher(canned, bound, shards)
+			var next atomic.Int64
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, _, err := cache.Fetch(urls[next.Add(1)%pages]); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestCacheFetcherIncremental(t *testing.T) {
+	var version atomic.Int64
+	version.Store(1)
+	var requests, notModified atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		etag := `"v1"`
+		if r.URL.Path == "/edited" {
+			etag = fmt.Sprintf(`"v%d"`, version.Load())
+		}
+		if r.URL.Path != "/plain" {
+			w.Header().Set("ETag", etag)
+			if r.Header.Get("If-None-Match") == etag {
+				notModified.Add(1)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		fmt.Fprintf(w, "%s %s", r.URL.Path, etag)
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "cache.json")
+	saved := NewCacheFetcher(NewHTTPFetcher(nil))
+	for _, page := range []string{"/same", "/edited", "/plain"} {
+		if _, err := saved.FetchPage(srv.URL + page); err != nil {
+			t.Fatalf("FetchPage(%q): %v", page, err)
+		}
+	}
+	if err := saved.SaveToFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	version.Store(2)
+	requests.Store(0)
+	cache := NewCacheFetcher(NewHTTPFetcher(nil))
+	cache.Incremental = true
+	cache.Stats = NewStatsCollector()
+	if err := cache.LoadFromFile(path); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"/same": true, "/edited": false, "/plain": true, "/new": false}
+	for _, page := range []string{"/same", "/edited", "/plain", "/new"} {
+		got, err := cache.FetchPage(srv.URL + page)
+		if err != nil {
+			t.Fatalf("FetchPage(%q): %v", page, err)
+		}
+		if got.Unchanged != want[page] {
+			t.Errorf("%s: Unchanged = %v, want %v", page, got.Unchanged, want[page])
+		}
+	}
+	if _, err := cache.FetchPage(srv.URL + "/same"); err != nil {
+		t.Fatal(err)
+	}
+	if n, m := requests.Load(), notModified.Load(); n != 4 || m != 1 {
+		t.Errorf("server got %d requests and sent %d 304s, want one request per page, the refetch served from the cache, and a 304 for /same", n, m)
+	}
+	if s := cache.Stats.Stats(); s.PagesUnchanged != 2 || s.PagesChanged != 1 {
+		t.Errorf("pages unchanged, changed = %d, %d; want 2, 1", s.PagesUnchanged, s.PagesChanged)
+	}
+}
+
+func TestCacheFetcherBound(t *testing.T) {
+	for _, maxEntries := range []int{1, 5, 10, DefaultCacheShards, DefaultCacheShards + 1, 100} {
+		t.Run(fmt.Sprint(maxEntries), func(t *testing.T) {
+			pages := make(fakeFetcher)
+			cache := NewShardedCacheFetcher(pages, maxEntries, DefaultCacheShards)
+			for i := range 3 * maxEntries {
+				url := fmt.Sprintf("https://example.com/%d", i)
+				pages[url] = &fakeResult{body: url}
+				if _, _, err := cache.Fetch(url); err != nil {
+					t.Fatal(err)
+				}
+				if n := cache.Len(); n > maxEntries {
+					t.Fatalf("after %d pages the cache holds %d, want at most %d", i+1, n, maxEntries)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheFetcherEvictionOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		fetch  string
+		cached string
+	}{
+		{"within the bound", "abab", "--++"},
+		{"evicts the least recently fetched", "abca", "----"},
+		{"keeps a page fetched again", "abacab", "--+-+-"},
+		{"evicts in order of use", "abacbca", "--+--+-"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pages := make(fakeFetcher)
+			for _, name := range tt.fetch {
+				url := "https://example.com/" + string(name)
+				pages[url] = &fakeResult{body: url}
+			}
+			cache := NewBoundedCacheFetcher(pages, 2)
+			var got []byte
+			for _, name := range tt.fetch {
+				page, err := cache.FetchPage("https://example.com/" + string(name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if page.Cached {
+					got = append(got, '+')
+				} else {
+					got = append(got, '-')
+				}
+			}
+			if string(got) != tt.cached {
+				t.Errorf("fetching %s served from the cache %s, want %s", tt.fetch, got, tt.cached)
+			}
+		})
+	}
+}
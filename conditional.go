@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotModified is returned by a fetcher asked for a page with the
+// Validators of a cached copy when the server answers that the copy is
+// still current, as with 304 Not Modified. CacheFetcher then serves the
+// copy.
+var ErrNotModified = errors.New("not modified")
+
+// Validators identify the version of the page at URL held in a cache,
+// by its ETag and Last-Modified headers, for a conditional request.
+type Validators struct {
+	URL          string
+	ETag         string
+	LastModified string
+}
+
+type validatorsKey struct{}
+
+// WithValidators returns a copy of ctx asking for the page at v.URL only
+// if it has changed since the version v identifies. The fetches of other
+// URLs made with ctx, such as that of robots.txt, are unaffected.
+func WithValidators(ctx context.Context, v Validators) context.Context {
+	return context.WithValue(ctx, validatorsKey{}, v)
+}
+
+// ValidatorsFor returns the validators ctx carries for url, if any.
+func ValidatorsFor(ctx context.Context, url string) (Validators, bool) {
+	v, ok := ctx.Value(validatorsKey{}).(Validators)
+	if !ok || urlKey(v.URL) != urlKey(url) || v.ETag == "" && v.LastModified == "" {
+		return Validators{}, false
+	}
+	return v, true
+}
@@ -0,0 +1,831 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultDepth is the default for Config.Depth.
+const DefaultDepth = 3
+
+// UnlimitedDepth is the Config.Depth of a crawl without a depth limit.
+const UnlimitedDepth = -1
+
+// DefaultConcurrency is the default for Config.Concurrency.
+const DefaultConcurrency = 10
+
+// DefaultMaxPending is the default for Config.MaxPending.
+const DefaultMaxPending = 1024
+
+// Config configures a Crawler. The zero value crawls the seeds' hosts
+// over HTTP, depth-first, to DefaultDepth levels with DefaultConcurrency
+// fetches at a time, and discards the results.
+type Config struct {
+	// Depth is the number of levels to crawl, counting the seed as the
+	// first. Zero means DefaultDepth and UnlimitedDepth, or any negative
+	// value, crawls everything reachable; the visited set still fetches
+	// each page once, so cycles end, and MaxPages can bound the crawl.
+	Depth int
+	// MaxPathDepth, if positive, also limits the crawl by the number of
+	// segments in the URL path, whatever the number of links followed:
+	// with 3, /a/b/c is crawled but links to /a/b/c/d are not. Both
+	// limits apply, so the crawl stops at whichever is reached first.
+	// Seeds are crawled whatever their path.
+	MaxPathDepth int
+	// Fetcher fetches the pages. If nil, pages are fetched over HTTP,
+	// obeying robots.txt for DefaultUserAgent, through a CacheFetcher
+	// holding DefaultCacheEntries pages, which Crawler.Close closes. A
+	// Fetcher set here is the caller's to close.
+	Fetcher Fetcher
+	// Concurrency bounds the number of fetches running at once. Zero
+	// means DefaultConcurrency and a negative value means no limit.
+	Concurrency int
+	// Adaptive, if set, limits the fetches running at once from each
+	// host by how the host responds, backing off as it fails or slows
+	// down and ramping up as it recovers, within the bounds it sets.
+	// Concurrency still bounds the fetches from all hosts together.
+	Adaptive *AdaptiveConcurrency
+	// MaxPending bounds the pages scheduled but not yet reported:
+	// waiting for a fetch slot, being fetched, or holding their body
+	// until Output takes the result. Once that many are pending, the
+	// pages finding new links wait before scheduling them, so a crawl
+	// outpacing its consumer slows down instead of piling up bodies in
+	// memory. Zero means DefaultMaxPending and a negative value means no
+	// limit.
+	MaxPending int
+	// InFlightWarning, if positive, is a soft limit on the fetches in
+	// progress at once: the first time a crawl goes past it a warning is
+	// logged, as a sign that Concurrency is unbounded or set higher than
+	// meant. Nothing is held back. The fetches are counted by Stats,
+	// which is created if unset; see also Stats.PeakInFlight.
+	InFlightWarning int
+	// MaxPages caps the number of pages fetched successfully. Zero
+	// means no cap.
+	MaxPages int
+	// MaxDuration, if positive, stops a crawl once it has run that long.
+	// Fetches in progress are abandoned, the results of the pages
+	// fetched by then are all reported, and the crawl returns
+	// ErrTimeLimit.
+	MaxDuration time.Duration
+	// Budget, if set, is charged for every fetch by its depth, failed
+	// ones included. Pages it can't pay for are skipped, so once it
+	// runs low only shallow pages are still fetched, and once it is
+	// spent the crawl stops. Revisits are free.
+	Budget *DepthBudget
+	// BreadthFirst crawls every page of one level before any page of the
+	// next, instead of following links as soon as they are found.
+	BreadthFirst bool
+	// Deterministic crawls breadth-first one page at a time, in the order
+	// the pages were found, so that the same site always gives the same
+	// results in the same order, for tests and for crawls snapshotted and
+	// diffed over time. It overrides BreadthFirst and Concurrency.
+	Deterministic bool
+	// Priority, if set, orders the pages of each level of a breadth-first
+	// crawl, highest score first. By default they are fetched in the
+	// order they were found.
+	Priority Priority
+	// Schedule orders the pages of each level of a breadth-first crawl
+	// across hosts, before Priority does. The default, FIFO, fetches
+	// them as they were found; RoundRobinHosts takes a page from each
+	// host in turn, so that on a crawl of several sites the biggest
+	// doesn't take up every fetch slot.
+	Schedule SchedulePolicy
+	// AnyHost follows links to any host. By default the crawl stays on
+	// the hosts in AllowedHosts or, if it is empty, on the seeds' hosts
+	// and, if Subdomains is set, their subdomains.
+	AnyHost    bool
+	Subdomains bool
+	// AllowedHosts lists the hosts the crawl may go to, as accepted by
+	// NewAllowlistScope: "example.com", "example.com:8080" or
+	// "*.example.com" for its subdomains.
+	AllowedHosts []string
+	// Filter, if set, decides which of the in-scope links are followed.
+	Filter LinkFilter
+	// StripParams lists the query parameters removed from links before
+	// they are deduplicated and followed, so that variants of a URL
+	// differing only in them, such as ?utm_source=x, are crawled once.
+	// A name ending in "*" matches every parameter with that prefix.
+	// Nil means DefaultStripParams; an empty slice strips nothing.
+	StripParams []string
+	// Dedup decides which parts of a URL tell pages apart when links are
+	// deduplicated. The default, FullURL, compares whole URLs; PathOnly
+	// ignores the query, so ?page=2 and ?page=3 of a path are crawled
+	// once, under whichever is found first; PathPlusWhitelistedParams
+	// ignores every query parameter but those in DedupParams. Pages are
+	// still fetched at the URL they were linked by.
+	Dedup DedupMode
+	// DedupParams lists the query parameters significant to the
+	// PathPlusWhitelistedParams mode, such as "page". A name ending in
+	// "*" matches every parameter with that prefix.
+	DedupParams []string
+	// CanonicalDedup treats a page declaring an in-scope canonical URL
+	// as that URL: it is reported under the canonical URL, which is
+	// marked visited, and if another page of the crawl was already
+	// reported under it, the canonical page itself or another variant,
+	// it is reported as a duplicate and its links are not followed.
+	// Likewise the canonical page is a duplicate if a variant was
+	// reported under its URL first. This collapses variants of a page, such as
+	// those differing in tracking parameters, into one.
+	CanonicalDedup bool
+	// Soft404, if set, is asked about every page fetched successfully.
+	// A page it flags is reported as failed with ErrSoft404, keeping
+	// its status, and its links are not followed.
+	Soft404 Soft404Detector
+	// Visited records the URLs scheduled so far. If nil, the Crawler
+	// starts with an empty MapVisitedSet; passing one in lets a crawl
+	// pick up where another stopped, or saves memory on huge crawls if
+	// it is a BloomVisitedSet.
+	Visited VisitedSet
+	// Graph, if set, records the links between fetched pages, with
+	// StripParams applied as it is to the links followed.
+	Graph *LinkGraph
+	// Stats, if set, counts fetches, bytes and errors.
+	Stats *StatsCollector
+	// Logger, if set, receives failed fetches, and the start and end of
+	// every fetch as debug messages. The messages of a fetch carry its
+	// request ID as "req", which is also passed down the fetchers in
+	// the context; see WithRequestID.
+	Logger *slog.Logger
+	// OnPage, if set, is called with every page fetched successfully.
+	OnPage PageHandler
+	// BodyProcessor, if set, is called with the URL and body of every
+	// page fetched successfully, and what it returns is the body of its
+	// result: the text extracted from it, say, or "" to drop it, so that
+	// results carry only what is needed and don't hold whole pages in
+	// memory. The links, duplicate detection and Soft404 all go by the
+	// body as fetched.
+	BodyProcessor func(url, body string) string
+	// Output, if set, receives every result, including failures. Run
+	// blocks while nobody receives from it and never closes it.
+	Output chan<- CrawlResult
+}
+
+// Crawler crawls sites as set out in its Config. A Crawler runs one
+// crawl at a time; successive runs share its VisitedSet, page limit and
+// duplicate detection.
+type Crawler struct {
+	cfg     Config
+	fetcher Fetcher
+	// own is the fetcher NewCrawler built for a nil Config.Fetcher, for
+	// Close to close.
+	own     Fetcher
+	visited VisitedSet
+	sem     Semaphore
+	pending Semaphore
+	// adaptive bounds the fetches from each host, within sem, if
+	// Config.Adaptive is set.
+	adaptive *AdaptiveLimit
+	limit    *PageLimit
+	bodies   *ContentSet
+	// reported holds the visit keys of the pages reported, for
+	// Config.CanonicalDedup.
+	reported *ContentSet
+	scope    *HostScope
+	// discovering is set for the duration of a Discover.
+	discovering bool
+	// requests numbers the fetches for their request IDs.
+	requests atomic.Uint64
+	// warnedInFlight is set once a crawl has warned of going past
+	// Config.InFlightWarning.
+	warnedInFlight atomic.Bool
+
+	subsMux     sync.RWMutex
+	subscribers []func(Event)
+
+	// unpaused is closed by Unpause; it is nil while not paused.
+	pauseMux sync.Mutex
+	unpaused chan struct{}
+
+	// done is closed when the crawl begun by Start ends, with runErr
+	// the error of its Run; failures are those of the last crawl.
+	waitMux  sync.Mutex
+	done     chan struct{}
+	runErr   error
+	failures []error
+
+	// meta holds the metadata of SetMeta, by urlKey of the URL.
+	metaMux sync.RWMutex
+	meta    map[string]map[string]any
+}
+
+// NewCrawler returns a Crawler for cfg, filling in the defaults of unset
+// fields.
+func NewCrawler(cfg Config) *Crawler {
+	if cfg.Depth == 0 {
+		cfg.Depth = DefaultDepth
+	}
+	if cfg.Concurrency == 0 {
+		cfg.Concurrency = DefaultConcurrency
+	}
+	if cfg.Deterministic {
+		cfg.BreadthFirst = true
+		cfg.Concurrency = 1
+	}
+	if cfg.MaxPending == 0 {
+		cfg.MaxPending = DefaultMaxPending
+	}
+	if cfg.InFlightWarning > 0 && cfg.Stats == nil {
+		cfg.Stats = NewStatsCollector()
+	}
+	if cfg.StripParams == nil {
+		cfg.StripParams = DefaultStripParams
+	}
+	fetcher, own := cfg.Fetcher, Fetcher(nil)
+	if fetcher == nil {
+		cache := NewBoundedCacheFetcher(NewRobotsFetcher(NewHTTPFetcher(nil), DefaultUserAgent), DefaultCacheEntries)
+		cache.Stats = cfg.Stats
+		cache.Logger = cfg.Logger
+		fetcher, own = cache, cache
+	}
+	visited := cfg.Visited
+	if visited == nil {
+		visited = NewVisitedSet()
+	}
+	c := &Crawler{
+		cfg:      cfg,
+		fetcher:  fetcher,
+		own:      own,
+		visited:  visited,
+		sem:      NewSemaphore(cfg.Concurrency),
+		pending:  NewSemaphore(cfg.MaxPending),
+		limit:    NewPageLimit(cfg.MaxPages),
+		bodies:   NewContentSet(),
+		reported: NewContentSet(),
+	}
+	if cfg.Adaptive != nil {
+		c.adaptive = NewAdaptiveLimit(*cfg.Adaptive, cfg.Concurrency, cfg.Logger)
+	}
+	return c
+}
+
+// Close waits for a crawl begun by Start to finish, then closes the
+// fetcher NewCrawler built if Config.Fetcher was nil, releasing its idle
+// HTTP connections. Run, Resume and Discover leave no goroutines behind,
+// and Close touches nothing the caller passed in: a Config.Fetcher, the
+// Config.Output channel and whatever files the results are written to
+// remain the caller's to close. Callers should defer it once the
+// Crawler is set up; it must not be called while a Run, Resume or
+// Discover is in progress.
+func (c *Crawler) Close() error {
+	c.waitMux.Lock()
+	done := c.done
+	c.waitMux.Unlock()
+	if done != nil {
+		<-done
+	}
+	return closeFetcher(c.own)
+}
+
+// Visited returns the set of URLs the Crawler has scheduled.
+func (c *Crawler) Visited() VisitedSet {
+	return c.visited
+}
+
+// ErrNoSeeds is returned by Run and Resume when called without seeds.
+var ErrNoSeeds = errors.New("no seed URLs")
+
+// ErrTimeLimit is returned by Run, Resume and Discover when the crawl
+// was stopped by Config.MaxDuration. It wraps context.DeadlineExceeded.
+var ErrTimeLimit = fmt.Errorf("crawl time limit reached: %w", context.DeadlineExceeded)
+
+// Run crawls from seeds, all at depth 0, and returns once the crawl has
+// finished. The seeds are one crawl: they share the visited set and the
+// page limit, and the crawl may go to the hosts of all of them. If ctx
+// is cancelled no further pages are fetched and Run returns ctx.Err()
+// once the fetches in progress have been abandoned; pages whose fetch
+// had already succeeded are still reported.
+func (c *Crawler) Run(ctx context.Context, seeds ...string) error {
+	ctx, cancel, err := c.start(ctx, seeds)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	var frontier []FrontierEntry
+	for _, seed := range seeds {
+		if ok, _ := c.visit(seed, 0); ok {
+			frontier = append(frontier, FrontierEntry{URL: seed, Depth: 0})
+		}
+	}
+	if c.cfg.BreadthFirst {
+		c.resume(ctx, frontier)
+		return c.finish(ctx)
+	}
+	var wg sync.WaitGroup
+	for _, entry := range frontier {
+		if c.limit.Reached() || c.pending.Acquire(ctx) != nil {
+			break
+		}
+		wg.Add(1)
+		go c.crawl(ctx, &wg, entry.URL, 0, nil, false)
+	}
+	wg.Wait()
+	return c.finish(ctx)
+}
+
+// Resume crawls breadth-first from every entry of frontier, shallowest
+// first, as far as Config.Depth levels from the seeds, whose hosts set
+// the scope. The frontier URLs should already be recorded in the
+// Crawler's VisitedSet. If ctx is cancelled or the page limit is
+// reached, Resume returns the entries it did not get to, which can be
+// saved in a CrawlState and passed to a later Resume to continue.
+func (c *Crawler) Resume(ctx context.Context, seeds []string, frontier []FrontierEntry) ([]FrontierEntry, error) {
+	ctx, cancel, err := c.start(ctx, seeds)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	pending, _ := c.resume(ctx, frontier)
+	return pending, c.finish(ctx)
+}
+
+// Discover sizes up a crawl from seeds without making it: it crawls
+// breadth-first like Run, but reports results without bodies, and
+// instead of dropping the links found past the depth limit returns
+// them, each once, as the frontier a deeper crawl would go on to. The
+// pages are still downloaded to find their links. With an unlimited
+// depth the whole site is crawled and the frontier is empty. If ctx is
+// cancelled or the page limit is reached, the frontier also holds the
+// pages within the limit that were not fetched.
+func (c *Crawler) Discover(ctx context.Context, seeds ...string) ([]FrontierEntry, error) {
+	ctx, cancel, err := c.start(ctx, seeds)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	c.discovering = true
+	defer func() { c.discovering = false }()
+	var frontier []FrontierEntry
+	for _, seed := range seeds {
+		if ok, _ := c.visit(seed, 0); ok {
+			frontier = append(frontier, FrontierEntry{URL: seed, Depth: 0})
+		}
+	}
+	pending, beyond := c.resume(ctx, frontier)
+	return append(pending, beyond...), c.finish(ctx)
+}
+
+// start sets up a crawl from seeds, returning the context to run it in,
+// which ends after Config.MaxDuration if that is set, and emits
+// CrawlStarted.
+func (c *Crawler) start(ctx context.Context, seeds []string) (context.Context, context.CancelFunc, error) {
+	if err := c.setScope(seeds); err != nil {
+		return nil, nil, err
+	}
+	c.resetFailures()
+	c.warnedInFlight.Store(false)
+	cancel := context.CancelFunc(func() {})
+	if c.cfg.MaxDuration > 0 {
+		ctx, cancel = context.WithTimeoutCause(ctx, c.cfg.MaxDuration, ErrTimeLimit)
+	}
+	c.emit(Event{Kind: CrawlStarted, Seeds: seeds})
+	return ctx, cancel, nil
+}
+
+// finish emits CrawlFinished with the result of the crawl run in ctx,
+// as returned by start, and returns it: nil, ctx.Err(), or ErrTimeLimit
+// if the time ran out.
+func (c *Crawler) finish(ctx context.Context) error {
+	err := ctx.Err()
+	if err != nil && context.Cause(ctx) == ErrTimeLimit {
+		err = ErrTimeLimit
+	}
+	c.emit(Event{Kind: CrawlFinished, Err: err})
+	return err
+}
+
+func (c *Crawler) setScope(seeds []string) error {
+	c.scope = nil
+	if len(seeds) == 0 {
+		return ErrNoSeeds
+	}
+	if c.cfg.AnyHost {
+		return nil
+	}
+	scope, err := NewSeedsScope(seeds, c.cfg.Subdomains)
+	if len(c.cfg.AllowedHosts) > 0 {
+		scope, err = NewAllowlistScope(c.cfg.AllowedHosts)
+	}
+	if err != nil {
+		return err
+	}
+	c.scope = scope
+	return nil
+}
+
+// within reports whether pages hops links away from the seed are within
+// the depth limit.
+func (c *Crawler) within(hops int) bool {
+	return c.cfg.Depth < 0 || hops < c.cfg.Depth
+}
+
+// report sends result on the configured output, if any.
+func (c *Crawler) report(result CrawlResult) {
+	if c.cfg.Output != nil {
+		c.cfg.Output <- result
+	}
+}
+
+// visit marks url visited at depth under its key for Config.Dedup; see
+// VisitedSet.
+func (c *Crawler) visit(url string, depth int) (crawl, revisit bool) {
+	return c.visited.Visit(dedupURL(url, c.cfg.Dedup, c.cfg.DedupParams), depth)
+}
+
+// visitKey returns the key url is visited under, alike for URLs naming
+// the same page.
+func (c *Crawler) visitKey(url string) string {
+	return urlKey(dedupURL(url, c.cfg.Dedup, c.cfg.DedupParams))
+}
+
+// follows reports whether the link from parent to child, depth links
+// from the seed, is in scope, within MaxPathDepth and passes the filter.
+func (c *Crawler) follows(parent, child string, depth int) bool {
+	if c.cfg.MaxPathDepth > 0 && pathDepth(child) > c.cfg.MaxPathDepth {
+		return false
+	}
+	return c.scope.Allows(child) && (c.cfg.Filter == nil || c.cfg.Filter(parent, child, depth))
+}
+
+// errOutOfScope marks a fetch that was redirected out of the crawl scope.
+var errOutOfScope = errors.New("redirected out of scope")
+
+// crawlPage fetches url and reports the outcome, returning the page's
+// links and whether the fetch succeeded. Nothing is reported if ctx is
+// cancelled before the fetch succeeds, the page limit has been reached,
+// robots.txt disallows url or url redirects out of scope. If url
+// redirects, the page is reported under its final URL, which is marked
+// as visited, unless the final URL was visited already, when nothing is
+// reported either. The links of a fetched page are recorded in the graph, but
+// not returned if the page is marked nofollow, and pages whose body was
+// seen before are reported as duplicates. Fetched pages and errors are
+// counted in the stats, failed fetches are logged, and successful ones
+// passed through BodyProcessor and to OnPage if they are set.
+//
+// A revisit of a page already reported is sent as a result with Revisit
+// set, but is not counted against the limit, in the stats or the graph,
+// nor passed to OnPage again. A failed revisit is not reported at all.
+func (c *Crawler) crawlPage(ctx context.Context, url string, depth int, path []string, revisit bool) ([]string, bool) {
+	if !c.admit(ctx, url, depth, revisit) {
+		return nil, false
+	}
+	return c.fetchAdmitted(ctx, url, depth, path, revisit)
+}
+
+// admit waits for a slot to fetch url and for the Crawler to be
+// unpaused and, unless the fetch is a revisit, claims a page from the
+// limit and pays for a page at depth from the budget. It reports false,
+// holding nothing, if ctx is cancelled first, the limit has been
+// reached or the budget falls short.
+func (c *Crawler) admit(ctx context.Context, url string, depth int, revisit bool) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	// The host's slot comes first, so that fetches waiting on a host
+	// that backed off don't hold slots the other hosts could use.
+	if c.adaptive.Acquire(ctx, url) != nil {
+		return false
+	}
+	if c.sem.Acquire(ctx) != nil {
+		c.adaptive.Release(url)
+		return false
+	}
+	// Waiting with the slot in hand keeps a fetch queued behind the
+	// semaphore from slipping through just after a Pause.
+	if c.waitUnpaused(ctx) != nil {
+		c.releaseSlot(url)
+		return false
+	}
+	if revisit {
+		return true
+	}
+	if !c.limit.Reserve() {
+		c.releaseSlot(url)
+		return false
+	}
+	if !c.cfg.Budget.Spend(depth) {
+		c.limit.Release()
+		c.releaseSlot(url)
+		return false
+	}
+	return true
+}
+
+// releaseSlot frees the slots admit took to fetch url.
+func (c *Crawler) releaseSlot(url string) {
+	c.sem.Release()
+	c.adaptive.Release(url)
+}
+
+// fetchAdmitted is crawlPage for a fetch that admit let through.
+func (c *Crawler) fetchAdmitted(ctx context.Context, url string, depth int, path []string, revisit bool) ([]string, bool) {
+	ctx = WithRequestID(ctx, c.requests.Add(1))
+	logger := fetchLogger(ctx, c.cfg.Logger)
+	logger.Debug("fetch started", "url", url, "depth", depth)
+	stats := c.cfg.Stats
+	if n := stats.fetchStarted(); c.cfg.InFlightWarning > 0 && n > int64(c.cfg.InFlightWarning) && !c.warnedInFlight.Swap(true) {
+		logger.Warn("fetches in flight past the soft limit", "in_flight", n, "limit", c.cfg.InFlightWarning)
+	}
+	start := time.Now()
+	page, err := fetchContext(ctx, c.fetcher, url)
+	took := time.Since(start)
+	stats.fetchDone(took)
+	if ctx.Err() == nil && (err != nil || !page.Cached) {
+		c.adaptive.Observe(url, err, took)
+	}
+	c.releaseSlot(url)
+	if err == nil && page.URL != url && !c.scope.Allows(page.URL) {
+		err = errOutOfScope
+	}
+	if err != nil && !revisit {
+		c.limit.Release()
+	}
+	if err != nil && (ctx.Err() != nil || errors.Is(err, ErrDisallowed) || err == errOutOfScope || revisit) {
+		return nil, false
+	}
+	if err != nil {
+		result := CrawlResult{URL: url, Depth: depth, Path: path, Duration: took, Err: err}
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) {
+			result.StatusCode = statusErr.StatusCode
+		}
+		c.fetchFailed(logger, result)
+		return nil, false
+	}
+
+	logger.Debug("fetch done", "url", page.URL, "status", page.StatusCode, "bytes", len(page.Body), "took", took)
+	if c.visitKey(page.URL) != c.visitKey(url) {
+		// A redirect to a page visited already, or being fetched, has
+		// nothing to add to that page's result.
+		if crawl, _ := c.visit(page.URL, depth); !crawl {
+			if !revisit {
+				c.limit.Release()
+			}
+			logger.Debug("redirected to a visited page", "url", url, "location", page.URL)
+			return nil, false
+		}
+	}
+	result := CrawlResult{
+		URL:          page.URL,
+		Body:         page.Body,
+		Links:        page.Links,
+		StatusCode:   page.StatusCode,
+		Truncated:    page.Truncated,
+		Canonical:    page.Canonical,
+		NoIndex:      page.NoIndex,
+		Depth:        depth,
+		Path:         path,
+		Duration:     took,
+		Headers:      page.Headers,
+		LastModified: page.LastModified,
+		Unchanged:    page.Unchanged,
+		Revisit:      revisit,
+	}
+	if c.cfg.Soft404 != nil && c.cfg.Soft404(result) {
+		if !revisit {
+			c.limit.Release()
+			c.fetchFailed(logger, CrawlResult{
+				URL:        page.URL,
+				StatusCode: page.StatusCode,
+				Depth:      depth,
+				Path:       path,
+				Duration:   took,
+				Err:        fmt.Errorf("fetch %s: %w", page.URL, ErrSoft404),
+			})
+		}
+		return nil, false
+	}
+	links := page.Links
+	if page.NoFollow {
+		links = nil
+	}
+	if c.cfg.CanonicalDedup && page.Canonical != "" && urlKey(page.Canonical) != urlKey(page.URL) && c.scope.Allows(page.Canonical) {
+		result.URL = page.Canonical
+		// Marked visited, the canonical URL is not fetched again.
+		c.visit(page.Canonical, depth)
+	}
+	// Of the pages reported under one URL, the page itself and the
+	// variants declaring it canonical, the first is the original; a
+	// page merely scheduled or failing doesn't count.
+	if c.cfg.CanonicalDedup && !revisit && c.reported.Add(c.visitKey(result.URL)) {
+		result.Duplicate = true
+		links = nil
+	}
+	if c.cfg.BodyProcessor != nil && !c.discovering {
+		result.Body = c.cfg.BodyProcessor(page.URL, page.Body)
+	}
+	if !revisit {
+		stats.pageFetched(len(page.Body))
+		if c.cfg.Graph != nil {
+			stripped := make([]string, len(page.Links))
+			for i, link := range page.Links {
+				stripped[i] = stripParams(link, c.cfg.StripParams)
+			}
+			c.cfg.Graph.AddEdges(page.URL, stripped)
+		}
+		result.Duplicate = c.bodies.Add(page.Body) || result.Duplicate
+		if c.discovering {
+			result.Body = ""
+		}
+		if c.cfg.OnPage != nil {
+			c.cfg.OnPage(result)
+		}
+	}
+	c.report(result)
+	c.emit(Event{Kind: PageFetched, URL: result.URL, Depth: depth, Result: &result})
+	return links, true
+}
+
+// fetchFailed logs, counts and reports the failed result.
+func (c *Crawler) fetchFailed(logger *slog.Logger, result CrawlResult) {
+	c.cfg.Stats.fetchFailed()
+	c.addFailure(result.Err)
+	logger.Warn("fetch failed", "url", result.URL, "took", result.Duration, "err", result.Err)
+	c.report(result)
+	c.emit(Event{Kind: PageErrored, URL: result.URL, Depth: result.Depth, Result: &result, Err: result.Err})
+}
+
+// crawl recursively crawls url, hops links away from the seed, spawning
+// a goroutine for every new link within the depth limit.
+// URLs already visited are skipped unless they are reached by a shorter
+// path than before, in which case the page is reported again as a
+// revisit at its new depth and crawled from there; revisit is set for
+// such a page. Once ctx is cancelled or the page limit reached no new
+// goroutines are spawned. The caller holds a pending slot for url, which
+// crawl releases once the page is reported and before waiting for slots
+// for its children, so the pages holding slots never wait on each other.
+// path is the breadcrumb trail to url.
+func (c *Crawler) crawl(ctx context.Context, wg *sync.WaitGroup, url string, hops int, path []string, revisit bool) {
+	defer wg.Done()
+	if !c.within(hops) {
+		c.pending.Release()
+		return
+	}
+
+	urls, ok := c.crawlPage(ctx, url, hops, path, revisit)
+	c.pending.Release()
+	if !ok || !c.within(hops+1) {
+		// Children would not be fetched, so don't mark them visited;
+		// a shorter path may still reach them.
+		return
+	}
+	// Claim every link before crawling any, so that a fast sibling
+	// can't reach one of them first by a longer path.
+	type child struct {
+		url     string
+		revisit bool
+	}
+	var children []child
+	for _, u := range urls {
+		u = stripParams(u, c.cfg.StripParams)
+		if !c.follows(url, u, hops+1) {
+			continue
+		}
+		if ok, revisit := c.visit(u, hops+1); ok {
+			children = append(children, child{u, revisit})
+			c.emit(Event{Kind: LinkDiscovered, URL: u, Depth: hops + 1, Parent: url})
+		}
+	}
+	childPath := append(slices.Clip(path), url)
+	for _, child := range children {
+		if c.limit.Reached() || c.pending.Acquire(ctx) != nil {
+			return
+		}
+		wg.Add(1)
+		go c.crawl(ctx, wg, child.url, hops+1, childPath, child.revisit)
+	}
+}
+
+// resume implements Resume once the scope is set, returning the pending
+// entries and, for Discover, those past the depth limit. The pages of a
+// level are admitted in priority order, so that the most valuable ones
+// are fetched first and get the page limit's slots if it runs out.
+func (c *Crawler) resume(ctx context.Context, frontier []FrontierEntry) ([]FrontierEntry, []FrontierEntry) {
+	queue := newFrontierQueue(c.cfg.Priority, c.cfg.Schedule)
+	for _, entry := range frontier {
+		queue.push(entry)
+	}
+	for queue.Len() > 0 {
+		depth := queue.peek().Depth
+		if !c.within(depth) {
+			if c.discovering {
+				return nil, queue.entries()
+			}
+			return nil, nil
+		}
+
+		var (
+			wg      sync.WaitGroup
+			mux     sync.Mutex
+			next    []FrontierEntry
+			pending []FrontierEntry
+		)
+		for queue.Len() > 0 && queue.peek().Depth == depth {
+			if c.limit.Reached() {
+				break
+			}
+			entry := queue.pop()
+			if c.pending.Acquire(ctx) != nil {
+				pending = append(pending, entry)
+				break
+			}
+			if !c.admit(ctx, entry.URL, depth, false) {
+				c.pending.Release()
+				if ctx.Err() == nil && !c.limit.Reached() {
+					// The budget can't pay for the page; perhaps for
+					// a shallower one.
+					continue
+				}
+				pending = append(pending, entry)
+				break
+			}
+			fetch := func() {
+				urls, ok := c.fetchAdmitted(ctx, entry.URL, depth, entry.Path, false)
+				c.pending.Release()
+				if !ok {
+					if ctx.Err() != nil || c.limit.Reached() {
+						mux.Lock()
+						pending = append(pending, entry)
+						mux.Unlock()
+					}
+					return
+				}
+				// Discover queues the next level without fetching it.
+				if !c.within(depth+1) && !c.discovering {
+					return
+				}
+				childPath := append(slices.Clip(entry.Path), entry.URL)
+				for _, child := range urls {
+					child = stripParams(child, c.cfg.StripParams)
+					// Levels are crawled shallowest first, so the first
+					// visit is always by a shortest path.
+					if !c.follows(entry.URL, child, depth+1) {
+						continue
+					}
+					if ok, _ := c.visit(child, depth+1); !ok {
+						continue
+					}
+					c.emit(Event{Kind: LinkDiscovered, URL: child, Depth: depth + 1, Parent: entry.URL})
+					mux.Lock()
+					next = append(next, FrontierEntry{URL: child, Depth: depth + 1, Path: childPath})
+					mux.Unlock()
+				}
+			}
+			// Fetching in the calling goroutine finishes each page,
+			// its result reported and its links queued, before the
+			// next one starts.
+			if c.cfg.Deterministic {
+				fetch()
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				fetch()
+			}()
+		}
+		wg.Wait()
+		for _, entry := range next {
+			queue.push(entry)
+		}
+		if len(pending) > 0 || ctx.Err() != nil || c.limit.Reached() {
+			return append(pending, queue.entries()...), nil
+		}
+	}
+	return nil, nil
+}
+
+// Crawl crawls from url to depth levels with fetcher, using the
+// defaults of Config for everything else, and sends every result to out
+// as it comes. It returns once the crawl has finished, without closing
+// out, with the error of Run.
+//
+// Deprecated: Use NewCrawler with Config.Output and call Run, which
+// accepts the other options too.
+func Crawl(ctx context.Context, url string, depth int, fetcher Fetcher, out chan<- CrawlResult) error {
+	return NewCrawler(Config{Depth: depth, Fetcher: fetcher, Output: out}).Run(ctx, url)
+}
+
+// CrawlAll crawls from seed to depth levels with fetcher, using the
+// defaults of Config for everything else, and returns every result once
+// the crawl has finished. If ctx is cancelled it returns the results
+// collected so far along with ctx.Err().
+func CrawlAll(ctx context.Context, seed string, depth int, fetcher Fetcher) ([]CrawlResult, error) {
+	output := make(chan CrawlResult)
+	collected := make(chan []CrawlResult)
+	go func() {
+		var results []CrawlResult
+		for result := range output {
+			results = append(results, result)
+		}
+		collected <- results
+	}()
+	err := NewCrawler(Config{Depth: depth, Fetcher: fetcher, Output: output}).Run(ctx, seed)
+	close(output)
+	return <-collected, err
+}
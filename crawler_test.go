@@ -0,0 +1,514 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/metrics"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// perURLFetcher wraps a Fetcher, counting the calls for each URL.
+type perURLFetcher struct {
+	mux     sync.Mutex
+	calls   map[string]int
+	fetcher Fetcher
+}
+
+func (f *perURLFetcher) Fetch(url string) (string, []string, error) {
+	f.mux.Lock()
+	f.calls[url]++
+	f.mux.Unlock()
+	return f.fetcher.Fetch(url)
+}
+
+// cyclicFetcher serves pages that link back to the seed, to each other
+// and to themselves.
+var cyclicFetcher = fakeFetcher{
+	"https://example.com/": &fakeResult{
+		"home",
+		[]string{"https://example.com/a", "https://example.com/b"},
+	},
+	"https://example.com/a": &fakeResult{
+		"a",
+		[]string{"https://example.com/a", "https://example.com/b", "https://example.com/c", "https://example.com/"},
+	},
+	"https://example.com/b": &fakeResult{
+		"b",
+		[]string{"https://example.com/", "https://example.com/a"},
+	},
+	"https://example.com/c": &fakeResult{
+		"c",
+		[]string{"https://example.com/a", "https://example.com/c"},
+	},
+}
+
+func TestCrawlerCyclesUnlimitedDepth(t *testing.T) {
+	for _, breadthFirst := range []bool{false, true} {
+		name := "depth-first"
+		if breadthFirst {
+			name = "breadth-first"
+		}
+		t.Run(name, func(t *testing.T) {
+			counter := &perURLFetcher{calls: make(map[string]int), fetcher: cyclicFetcher}
+			c := NewCrawler(Config{
+				Depth:        UnlimitedDepth,
+				Fetcher:      counter,
+				BreadthFirst: breadthFirst,
+			})
+
+			done := make(chan error, 1)
+			go func() {
+				done <- c.Run(context.Background(), "https://example.com/")
+			}()
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatalf("Run: %v", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Run did not return; the crawl is stuck in a cycle")
+			}
+
+			want := make(map[string]int)
+			for url := range cyclicFetcher {
+				want[url] = 1
+			}
+			if !maps.Equal(counter.calls, want) {
+				t.Errorf("fetches per URL = %v, want each page once: %v", counter.calls, want)
+			}
+		})
+	}
+}
+
+// treeFetcher serves a tree of pages, each linking to fanout children,
+// levels deep.
+func treeFetcher(fanout, levels int) fakeFetcher {
+	f := make(fakeFetcher)
+	var add func(url string, level int)
+	add = func(url string, level int) {
+		res := &fakeResult{body: url}
+		if level < levels {
+			for i := range fanout {
+				child := fmt.Sprintf("%s%d/", url, i)
+				res.urls = append(res.urls, child)
+				add(child, level+1)
+			}
+		}
+		f[url] = res
+	}
+	add("https://example.com/", 1)
+	return f
+}
+
+// BenchmarkCrawlerOutputBuffer crawls a tree of 1111 pages, each fetch
+// taking up to a millisecond, and prints a line for each to a file, as
+// the command does, stalling for 10ms every 100 lines as a terminal or a
+// disk flushing can. It compares an unbuffered output channel with
+// buffered ones of some sizes. They come out alike: a fetch frees its
+// slot before its result is sent, so a stalled consumer holds up only
+// the pages waiting to be reported, and the others go on fetching.
+func BenchmarkCrawlerOutputBuffer(b *testing.B) {
+	pages := jitterFetcher{treeFetcher(10, 4)}
+	for _, size := range []int{0, 64, 1024} {
+		b.Run(fmt.Sprintf("buffer=%d", size), func(b *testing.B) {
+			f, err := os.Create(filepath.Join(b.TempDir(), "out"))
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer f.Close()
+			for range b.N {
+				output := make(chan CrawlResult, size)
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					n := 0
+					for result := range output {
+						printResult(f, result)
+						if n++; n%100 == 0 {
+							time.Sleep(10 * time.Millisecond)
+						}
+					}
+				}()
+				c := NewCrawler(Config{Depth: UnlimitedDepth, Fetcher: pages, Output: output})
+				if err := c.Run(context.Background(), "https://example.com/"); err != nil {
+					b.Fatal(err)
+				}
+				close(output)
+				<-done
+			}
+		})
+	}
+}
+
+func TestCrawlerHTTPFixture(t *testing.T) {
+	base := fixtureServer(t, map[string]string{
+		"/":       `<a href="/about">About</a> <a href="/old">Old</a> <a href="/broken">Broken</a> <a href="/gone">Gone</a> <a href="https://elsewhere.example/">Elsewhere</a>`,
+		"/about":  `<a href="/">Home</a> <a href="/team">Team</a>`,
+		"/team":   `<p>Too deep to be crawled.</p>`,
+		"/old":    fixtureRedirect + "/new",
+		"/new":    `<a href="/about">About</a>`,
+		"/broken": fixtureStatus + "500",
+	})
+	results, err := CrawlAll(context.Background(), base+"/", 2, NewHTTPFetcher(nil))
+	if err != nil {
+		t.Fatalf("CrawlAll: %v", err)
+	}
+
+	type outcome struct {
+		status int
+		depth  int
+		failed bool
+	}
+	got := make(map[string]outcome)
+	for _, r := range results {
+		got[strings.TrimPrefix(r.URL, base)] = outcome{r.StatusCode, r.Depth, r.Err != nil}
+	}
+	want := map[string]outcome{
+		"/":       {200, 0, false},
+		"/about":  {200, 1, false},
+		"/new":    {200, 1, false},
+		"/broken": {500, 1, true},
+		"/gone":   {404, 1, true},
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("results = %v, want %v", got, want)
+	}
+}
+
+// bigPageFetcher serves an endless site of pages with 64 KiB bodies,
+// each linking to ten new pages.
+type bigPageFetcher struct {
+	pages atomic.Int64
+}
+
+func (f *bigPageFetcher) Fetch(url string) (string, []string, error) {
+	links := make([]string, 10)
+	for i := range links {
+		links[i] = fmt.Sprintf("https://example.com/%d", f.pages.Add(1))
+	}
+	return strings.Repeat("x", 64<<10), links, nil
+}
+
+// BenchmarkCrawlerMemory crawls 2000 pages of 64 KiB without a
+// concurrency limit into a consumer slower than the fetcher, reporting
+// the peak of the live heap as of each garbage collection. Without a
+// MaxPending bound the fetched pages pile up waiting for the consumer;
+// with one the heap stays flat however long the crawl runs.
+func BenchmarkCrawlerMemory(b *testing.B) {
+	for _, maxPending := range []int{-1, 64} {
+		name := fmt.Sprintf("max-pending=%d", maxPending)
+		if maxPending < 0 {
+			name = "max-pending=unlimited"
+		}
+		b.Run(name, func(b *testing.B) {
+			var peak uint64
+			for range b.N {
+				runtime.GC()
+				stop := make(chan struct{})
+				sampled := make(chan uint64)
+				go func() {
+					live := []metrics.Sample{{Name: "/gc/heap/live:bytes"}}
+					var peak uint64
+					for {
+						metrics.Read(live)
+						peak = max(peak, live[0].Value.Uint64())
+						select {
+						case <-stop:
+							sampled <- peak
+							return
+						case <-time.After(time.Millisecond):
+						}
+					}
+				}()
+
+				output := make(chan CrawlResult)
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					for range output {
+						time.Sleep(500 * time.Microsecond)
+					}
+				}()
+				c := NewCrawler(Config{
+					Depth:       UnlimitedDepth,
+					Fetcher:     &bigPageFetcher{},
+					Concurrency: -1,
+					MaxPending:  maxPending,
+					MaxPages:    2000,
+					Output:      output,
+				})
+				if err := c.Run(context.Background(), "https://example.com/"); err != nil {
+					b.Fatal(err)
+				}
+				close(output)
+				<-done
+				close(stop)
+				peak = max(peak, <-sampled)
+			}
+			b.ReportMetric(float64(peak)/(1<<20), "peak-MiB")
+		})
+	}
+}
+
+// jitterFetcher wraps a Fetcher, delaying every fetch by up to a
+// millisecond so that concurrent fetches finish in a random order.
+type jitterFetcher struct {
+	fetcher Fetcher
+}
+
+func (f jitterFetcher) Fetch(url string) (string, []string, error) {
+	time.Sleep(rand.N(time.Millisecond))
+	return f.fetcher.Fetch(url)
+}
+
+func TestCrawlerDeterministic(t *testing.T) {
+	pages := treeFetcher(3, 3)
+	var want []string
+	level := []string{"https://example.com/"}
+	for len(level) > 0 {
+		want = append(want, level...)
+		var next []string
+		for _, url := range level {
+			next = append(next, pages[url].urls...)
+		}
+		level = next
+	}
+
+	for range 3 {
+		output := make(chan CrawlResult, len(pages))
+		c := NewCrawler(Config{
+			Depth:         UnlimitedDepth,
+			Fetcher:       jitterFetcher{pages},
+			Concurrency:   8,
+			Deterministic: true,
+			Output:        output,
+		})
+		if err := c.Run(context.Background(), "https://example.com/"); err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		close(output)
+		var got []string
+		for result := range output {
+			got = append(got, result.URL)
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("results in order %q, want %q", got, want)
+		}
+	}
+}
+
+func TestCrawlerMeta(t *testing.T) {
+	c := NewCrawler(Config{Depth: UnlimitedDepth, Fetcher: cyclicFetcher})
+	c.Subscribe(func(e Event) {
+		if e.Kind == PageFetched {
+			c.SetMeta(e.URL, "length", len(e.Result.Body))
+		}
+	})
+	if err := c.Run(context.Background(), "https://example.com/"); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	c.SetMeta("https://example.com/a", "tag", "letter")
+
+	for url, page := range cyclicFetcher {
+		if got, ok := c.GetMeta(url, "length"); !ok || got != len(page.body) {
+			t.Errorf("GetMeta(%q, \"length\") = %v, %v; want %d, true", url, got, ok, len(page.body))
+		}
+	}
+	want := map[string]any{"length": 1, "tag": "letter"}
+	if got := c.Meta("HTTPS://EXAMPLE.COM/a/"); !maps.Equal(got, want) {
+		t.Errorf("Meta of the same page by another URL = %v, want %v", got, want)
+	}
+	if got, ok := c.GetMeta("https://example.com/missing", "length"); ok {
+		t.Errorf("GetMeta of an uncrawled page = %v, want none", got)
+	}
+}
+
+func TestCrawlerBodyProcessor(t *testing.T) {
+	output := make(chan CrawlResult, len(cyclicFetcher))
+	c := NewCrawler(Config{
+		Depth:   UnlimitedDepth,
+		Fetcher: cyclicFetcher,
+		Output:  output,
+		BodyProcessor: func(url, body string) string {
+			if url == "https://example.com/a" {
+				return ""
+			}
+			return strings.ToUpper(body)
+		},
+	})
+	if err := c.Run(context.Background(), "https://example.com/"); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	close(output)
+	got := make(map[string]string)
+	for result := range output {
+		got[result.URL] = result.Body
+	}
+	// The links of a are still followed to c.
+	want := map[string]string{
+		"https://example.com/":  "HOME",
+		"https://example.com/a": "",
+		"https://example.com/b": "B",
+		"https://example.com/c": "C",
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("bodies = %q, want %q", got, want)
+	}
+}
+
+func TestCrawlStreams(t *testing.T) {
+	out := make(chan CrawlResult, 64)
+	if err := Crawl(context.Background(), "https://example.com/", UnlimitedDepth, cyclicFetcher, out); err != nil {
+		t.Fatalf("Crawl: %v", err)
+	}
+	close(out)
+	got := make(map[string]bool)
+	for result := range out {
+		got[result.URL] = true
+	}
+	for url := range cyclicFetcher {
+		if !got[url] {
+			t.Errorf("Crawl did not report %s", url)
+		}
+	}
+}
+
+func TestCrawlerRedirectToVisitedPage(t *testing.T) {
+	base := fixtureServer(t, map[string]string{
+		"/":     `<a href="/new">New</a> <a href="/old1">Old</a> <a href="/old2">Older</a>`,
+		"/new":  `<a href="/">Home</a>`,
+		"/old1": fixtureRedirect + "/new",
+		"/old2": fixtureRedirect + "/new",
+		"/dir/": `<a href="/">Home</a>`,
+		"/dir":  fixtureRedirect + "/dir/",
+	})
+	results, err := CrawlAll(context.Background(), base+"/", 2, NewHTTPFetcher(nil))
+	if err != nil {
+		t.Fatalf("CrawlAll: %v", err)
+	}
+	reported := make(map[string]int)
+	for _, r := range results {
+		reported[strings.TrimPrefix(r.URL, base)]++
+	}
+	if want := map[string]int{"/": 1, "/new": 1}; !maps.Equal(reported, want) {
+		t.Errorf("results per URL = %v, want %v", reported, want)
+	}
+
+	// A redirect adding a trailing slash lands on the same page.
+	results, err = CrawlAll(context.Background(), base+"/dir", 1, NewHTTPFetcher(nil))
+	if err != nil {
+		t.Fatalf("CrawlAll: %v", err)
+	}
+	if len(results) != 1 || results[0].URL != base+"/dir/" {
+		t.Errorf("results = %v, want the page reported under %s/dir/", results, base)
+	}
+}
+
+func TestCrawlerCanonicalDedup(t *testing.T) {
+	base := fixtureServer(t, map[string]string{
+		"/":        `<a href="/broken">Broken</a> <a href="/variant">Variant</a> <a href="/good">Good</a> <a href="/copy">Copy</a>`,
+		"/broken":  fixtureStatus + "500",
+		"/variant": `<link rel="canonical" href="/broken"><p>variant</p>`,
+		"/good":    `<p>good</p>`,
+		"/copy":    `<link rel="canonical" href="/good"><p>copy</p>`,
+	})
+	output := make(chan CrawlResult, 16)
+	c := NewCrawler(Config{
+		Depth:          2,
+		Fetcher:        NewHTTPFetcher(nil),
+		CanonicalDedup: true,
+		Deterministic:  true,
+		Output:         output,
+	})
+	if err := c.Run(context.Background(), base+"/"); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	close(output)
+	type outcome struct{ failed, duplicate bool }
+	got := make(map[string][]outcome)
+	for r := range output {
+		url := strings.TrimPrefix(r.URL, base)
+		got[url] = append(got[url], outcome{r.Err != nil, r.Duplicate})
+	}
+	// The variant of the page that failed is the first reported under
+	// its URL, so it is no duplicate; the copy of the page that didn't
+	// is.
+	want := map[string][]outcome{
+		"/":       {{false, false}},
+		"/broken": {{true, false}, {false, false}},
+		"/good":   {{false, false}, {false, true}},
+	}
+	if !maps.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("results = %v, want %v", got, want)
+	}
+}
+
+// closeTracker is a fakeFetcher recording whether it was closed.
+type closeTracker struct {
+	fakeFetcher
+	closed bool
+}
+
+func (f *closeTracker) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestCrawlerCloseLeavesCallerFetcher(t *testing.T) {
+	pages := &closeTracker{fakeFetcher: fetcher}
+	output := make(chan CrawlResult)
+	var results int
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		for range output {
+			results++
+		}
+	}()
+	c := NewCrawler(Config{Depth: 4, Fetcher: pages, Output: output})
+	c.Start(context.Background(), "https://golang.org/")
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Close has waited for the crawl, so every result has been sent.
+	close(output)
+	<-drained
+	if results != 5 {
+		t.Errorf("got %d results before Close returned, want 5", results)
+	}
+	if pages.closed {
+		t.Error("Close closed the caller's Config.Fetcher")
+	}
+}
+
+func TestCrawlerGraphStripsParams(t *testing.T) {
+	pages := fakeFetcher{
+		"https://example.com/": &fakeResult{"home", []string{"https://example.com/missing?utm_source=home&id=1"}},
+	}
+	graph := NewLinkGraph()
+	errs := NewErrorCollector()
+	output := make(chan CrawlResult, 2)
+	if err := NewCrawler(Config{Depth: 2, Fetcher: pages, Graph: graph, Output: output}).Run(context.Background(), "https://example.com/"); err != nil {
+		t.Fatal(err)
+	}
+	close(output)
+	for result := range output {
+		if result.Err != nil {
+			errs.Add(result.URL, result.Err)
+		}
+	}
+	links := BrokenLinks(errs.Errors(), graph.Edges())
+	if len(links) != 1 || links[0].URL != "https://example.com/missing?id=1" || !slices.Equal(links[0].Parents, []string{"https://example.com/"}) {
+		t.Errorf("BrokenLinks = %+v, want https://example.com/missing?id=1 linked from https://example.com/", links)
+	}
+}
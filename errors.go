@@ -0,0 +1,106 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"syscall"
+)
+
+// ErrorCollector gathers the errors of a crawl by URL. The errors are
+// kept as returned by the fetcher, so they can still be inspected with
+// errors.Is and errors.As. It is safe for concurrent use.
+type ErrorCollector struct {
+	mux  sync.Mutex
+	errs map[string]error
+}
+
+func NewErrorCollector() *ErrorCollector {
+	return &ErrorCollector{
+		errs: make(map[string]error),
+	}
+}
+
+// Add records err as the error for url.
+func (c *ErrorCollector) Add(url string, err error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.errs[url] = err
+}
+
+// Errors returns a copy of the collected errors keyed by URL.
+func (c *ErrorCollector) Errors() map[string]error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	errs := make(map[string]error, len(c.errs))
+	for url, err := range c.errs {
+		errs[url] = err
+	}
+	return errs
+}
+
+// WriteSummary writes the collected errors to w grouped by ErrorKind,
+// largest group first.
+func (c *ErrorCollector) WriteSummary(w io.Writer) error {
+	groups := make(map[string][]string)
+	for url, err := range c.Errors() {
+		kind := ErrorKind(err)
+		groups[kind] = append(groups[kind], url)
+	}
+	kinds := make([]string, 0, len(groups))
+	for kind := range groups {
+		kinds = append(kinds, kind)
+	}
+	slices.SortFunc(kinds, func(a, b string) int {
+		if n := len(groups[b]) - len(groups[a]); n != 0 {
+			return n
+		}
+		return cmp.Compare(a, b)
+	})
+	for _, kind := range kinds {
+		urls := groups[kind]
+		slices.Sort(urls)
+		if _, err := fmt.Fprintf(w, "%s (%d):\n", kind, len(urls)); err != nil {
+			return err
+		}
+		for _, url := range urls {
+			if _, err := fmt.Fprintf(w, "  %s\n", url); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ErrorKind classifies a fetch error for reporting, e.g. "HTTP 404",
+// "timeout" or "DNS failure".
+func ErrorKind(err error) string {
+	var statusErr *StatusError
+	var dnsErr *net.DNSError
+	var certErr *tls.CertificateVerificationError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &statusErr):
+		return fmt.Sprintf("HTTP %d", statusErr.StatusCode)
+	case errors.As(err, &dnsErr):
+		return "DNS failure"
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "connection reset"
+	case errors.As(err, &certErr):
+		return "TLS certificate error"
+	case errors.Is(err, ErrTooManyRedirects):
+		return "too many redirects"
+	}
+	return "other"
+}
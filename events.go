@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+// EventKind identifies what an Event reports.
+type EventKind int
+
+const (
+	// CrawlStarted is emitted once Run or Resume has set up the crawl.
+	CrawlStarted EventKind = iota
+	// PageFetched is emitted for every page fetched successfully,
+	// revisits included, as it is reported.
+	PageFetched
+	// PageErrored is emitted for every fetch that failed.
+	PageErrored
+	// LinkDiscovered is emitted when a link is scheduled for crawling,
+	// once for every URL unless it is revisited by a shorter path.
+	LinkDiscovered
+	// CrawlFinished is emitted when Run or Resume returns.
+	CrawlFinished
+)
+
+var eventKindNames = [...]string{
+	CrawlStarted:   "CrawlStarted",
+	PageFetched:    "PageFetched",
+	PageErrored:    "PageErrored",
+	LinkDiscovered: "LinkDiscovered",
+	CrawlFinished:  "CrawlFinished",
+}
+
+func (k EventKind) String() string {
+	if k >= 0 && int(k) < len(eventKindNames) {
+		return eventKindNames[k]
+	}
+	return "EventKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Event is a moment in the life of a crawl, delivered to the handlers
+// registered with Crawler.Subscribe. Only the fields relevant to its
+// Kind are set.
+type Event struct {
+	Kind EventKind
+	Time time.Time
+	// Seeds are the seeds of the crawl, for CrawlStarted.
+	Seeds []string
+	// URL is the page fetched or the link discovered, and Depth its
+	// depth.
+	URL   string
+	Depth int
+	// Parent is the page a discovered link was found on.
+	Parent string
+	// Result is the result reported for a fetched or failed page.
+	Result *CrawlResult
+	// Err is the error of a failed page, or for CrawlFinished the
+	// error Run or Resume returns.
+	Err error
+}
+
+// Subscribe registers handler to be called with every event of the
+// Crawler's crawls. Handlers are called synchronously, from many
+// goroutines at once, so they must be fast and safe for concurrent use;
+// a slow handler slows the crawl down.
+func (c *Crawler) Subscribe(handler func(Event)) {
+	c.subsMux.Lock()
+	defer c.subsMux.Unlock()
+	// Copy on write, so that emit can use the slice without the lock.
+	c.subscribers = append(c.subscribers[:len(c.subscribers):len(c.subscribers)], handler)
+}
+
+// emit stamps e with the time and passes it to every subscriber.
+func (c *Crawler) emit(e Event) {
+	c.subsMux.RLock()
+	subscribers := c.subscribers
+	c.subsMux.RUnlock()
+	if len(subscribers) == 0 {
+		return
+	}
+	e.Time = time.Now()
+	for _, handler := range subscribers {
+		handler(e)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Fixture pages whose content starts with one of these prefixes are not
+// served as HTML: fixtureRedirect+"/path" redirects to /path and
+// fixtureStatus+"500" fails with that status.
+const (
+	fixtureRedirect = "redirect:"
+	fixtureStatus   = "status:"
+)
+
+// fixtureServer serves the site in pages, mapping paths to their HTML,
+// for the duration of the test and returns its base URL. Paths not in
+// pages are 404s.
+func fixtureServer(t *testing.T, pages map[string]string) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, ok := pages[r.URL.Path]
+		switch {
+		case !ok:
+			http.NotFound(w, r)
+		case strings.HasPrefix(page, fixtureRedirect):
+			http.Redirect(w, r, strings.TrimPrefix(page, fixtureRedirect), http.StatusFound)
+		case strings.HasPrefix(page, fixtureStatus):
+			code, err := strconv.Atoi(strings.TrimPrefix(page, fixtureStatus))
+			if err != nil {
+				t.Errorf("fixture %s: bad status %q", r.URL.Path, page)
+				code = http.StatusInternalServerError
+			}
+			http.Error(w, http.StatusText(code), code)
+		default:
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			io.WriteString(w, page)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
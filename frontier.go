@@ -0,0 +1,157 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Priority scores a URL found depth links from the seed; of the URLs
+// waiting at the same depth, those with higher scores are fetched first.
+type Priority func(url string, depth int) int
+
+// SchedulePolicy decides the order in which the pages of each level of
+// a breadth-first crawl are fetched; see Config.Schedule. It is a
+// flag.Value accepting "fifo" and "round-robin".
+type SchedulePolicy int
+
+const (
+	// FIFO fetches the pages in the order they were found.
+	FIFO SchedulePolicy = iota
+	// RoundRobinHosts takes the pages of each host in turn, in the
+	// order found for each host, so that a host with many links
+	// doesn't keep the others waiting behind its pages.
+	RoundRobinHosts
+)
+
+var schedulePolicyNames = []string{"fifo", "round-robin"}
+
+func (p SchedulePolicy) String() string {
+	if p < 0 || int(p) >= len(schedulePolicyNames) {
+		return fmt.Sprintf("SchedulePolicy(%d)", int(p))
+	}
+	return schedulePolicyNames[p]
+}
+
+func (p *SchedulePolicy) Set(name string) error {
+	i := slices.Index(schedulePolicyNames, name)
+	if i < 0 {
+		return fmt.Errorf("unknown schedule %q (want %s)", name, strings.Join(schedulePolicyNames, ", "))
+	}
+	*p = SchedulePolicy(i)
+	return nil
+}
+
+// frontierItem is a queued FrontierEntry with its score and its position
+// in the order of arrival, which breaks ties. round is the number of
+// entries of the same host and depth queued before it under
+// RoundRobinHosts, and zero under FIFO.
+type frontierItem struct {
+	entry FrontierEntry
+	round int
+	score int
+	seq   int
+}
+
+// frontierHeap implements heap.Interface, shallowest entry first, then
+// earliest round, then highest score, then first queued.
+type frontierHeap []frontierItem
+
+func (h frontierHeap) Len() int { return len(h) }
+
+func (h frontierHeap) Less(i, j int) bool {
+	a, b := h[i], h[j]
+	if a.entry.Depth != b.entry.Depth {
+		return a.entry.Depth < b.entry.Depth
+	}
+	if a.round != b.round {
+		return a.round < b.round
+	}
+	if a.score != b.score {
+		return a.score > b.score
+	}
+	return a.seq < b.seq
+}
+
+func (h frontierHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *frontierHeap) Push(x any) { *h = append(*h, x.(frontierItem)) }
+
+func (h *frontierHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// frontierQueue is the frontier of a breadth-first crawl, ordered by
+// depth and, within a depth, by the schedule and then by priority. A
+// nil priority keeps the entries of each depth in the order they were
+// queued, or for RoundRobinHosts of each host and round.
+type frontierQueue struct {
+	items    frontierHeap
+	priority Priority
+	seq      int
+	// rounds counts the entries queued by host and depth for
+	// RoundRobinHosts; it is nil under FIFO.
+	rounds map[hostDepth]int
+}
+
+type hostDepth struct {
+	host  string
+	depth int
+}
+
+func newFrontierQueue(priority Priority, schedule SchedulePolicy) *frontierQueue {
+	q := &frontierQueue{priority: priority}
+	if schedule == RoundRobinHosts {
+		q.rounds = make(map[hostDepth]int)
+	}
+	return q
+}
+
+func (q *frontierQueue) Len() int { return len(q.items) }
+
+func (q *frontierQueue) push(entry FrontierEntry) {
+	item := frontierItem{entry: entry, seq: q.seq}
+	if q.priority != nil {
+		item.score = q.priority(entry.URL, entry.Depth)
+	}
+	if q.rounds != nil {
+		key := hostDepth{entryHost(entry.URL), entry.Depth}
+		item.round = q.rounds[key]
+		q.rounds[key]++
+	}
+	q.seq++
+	heap.Push(&q.items, item)
+}
+
+// entryHost returns the lower-cased host of raw, or raw itself if it
+// can't be parsed, so that such entries each get a turn of their own.
+func entryHost(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return strings.ToLower(u.Host)
+}
+
+// peek returns the entry pop would return, without removing it.
+func (q *frontierQueue) peek() FrontierEntry {
+	return q.items[0].entry
+}
+
+func (q *frontierQueue) pop() FrontierEntry {
+	return heap.Pop(&q.items).(frontierItem).entry
+}
+
+// entries returns the queued entries in no particular order.
+func (q *frontierQueue) entries() []FrontierEntry {
+	entries := make([]FrontierEntry, len(q.items))
+	for i, item := range q.items {
+		entries[i] = item.entry
+	}
+	return entries
+}
@@ -1 +1,8 @@
 module github.com/maciekzieba/crawler
+
+go 1.27.1
+
+require (
+	golang.org/x/net v0.59.0
+	golang.org/x/sync v0.23.0
+)
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// LinkGraph records the links found on each crawled page.
+// It is safe for concurrent use; a nil LinkGraph records nothing.
+type LinkGraph struct {
+	mux   sync.Mutex
+	edges map[string][]string
+}
+
+func NewLinkGraph() *LinkGraph {
+	return &LinkGraph{
+		edges: make(map[string][]string),
+	}
+}
+
+// AddEdges records that parent links to each of children.
+func (g *LinkGraph) AddEdges(parent string, children []string) {
+	if g == nil {
+		return
+	}
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	g.edges[parent] = append(g.edges[parent], children...)
+}
+
+// Edges returns a copy of the graph as a map from each crawled page to
+// the URLs it links to.
+func (g *LinkGraph) Edges() map[string][]string {
+	if g == nil {
+		return nil
+	}
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	edges := make(map[string][]string, len(g.edges))
+	for parent, children := range g.edges {
+		edges[parent] = append([]string(nil), children...)
+	}
+	return edges
+}
+
+// WriteDOT writes graph, as returned by LinkGraph.Edges, to w in the
+// Graphviz DOT language, with a node for every URL and an edge for
+// every link, labelled with the full URLs. Render it with, say,
+// dot -Tpng. Nodes and edges are sorted, so the same graph always gives
+// the same output.
+func WriteDOT(w io.Writer, graph map[string][]string) error {
+	return WriteDOTLabels(w, graph, 0)
+}
+
+// WriteDOTLabels is WriteDOT with node labels cut to maxLabel runes,
+// ending in "…", so that large graphs stay legible. A maxLabel of zero
+// or less keeps labels whole.
+func WriteDOTLabels(w io.Writer, graph map[string][]string, maxLabel int) error {
+	nodes := make(map[string]bool)
+	edges := make(map[[2]string]bool)
+	for parent, children := range graph {
+		nodes[parent] = true
+		for _, child := range children {
+			nodes[child] = true
+			edges[[2]string{parent, child}] = true
+		}
+	}
+	bw := bufio.NewWriter(w)
+	bw.WriteString("digraph links {\n\tnode [shape=box];\n")
+	for _, node := range slices.Sorted(maps.Keys(nodes)) {
+		fmt.Fprintf(bw, "\t%s [label=%s];\n", dotQuote(node), dotQuote(dotLabel(node, maxLabel)))
+	}
+	for _, edge := range slices.SortedFunc(maps.Keys(edges), func(a, b [2]string) int {
+		return cmp.Or(cmp.Compare(a[0], b[0]), cmp.Compare(a[1], b[1]))
+	}) {
+		fmt.Fprintf(bw, "\t%s -> %s;\n", dotQuote(edge[0]), dotQuote(edge[1]))
+	}
+	bw.WriteString("}\n")
+	return bw.Flush()
+}
+
+// dotQuote returns s as a quoted DOT identifier. Inside quotes DOT only
+// treats a backslash before a quote specially, but backslashes are
+// escaped too so that label escapes like \n don't come out of a URL.
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// dotLabel returns url cut to max runes, or whole if max is zero or less.
+func dotLabel(url string, max int) string {
+	if max <= 0 || utf8.RuneCountInString(url) <= max {
+		return url
+	}
+	return string([]rune(url)[:max-1]) + "…"
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDOTQuote(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"https://example.com/", `"https://example.com/"`},
+		{`https://example.com/"quoted"`, `"https://example.com/\"quoted\""`},
+		{`https://example.com/a\b`, `"https://example.com/a\\b"`},
+		{`https://example.com/\n`, `"https://example.com/\\n"`},
+		{`https://example.com/\"`, `"https://example.com/\\\""`},
+		{"https://example.com/line\nbreak", `"https://example.com/line\nbreak"`},
+		{"https://example.com/ünïcode", `"https://example.com/ünïcode"`},
+	}
+	for _, tt := range tests {
+		if got := dotQuote(tt.in); got != tt.want {
+			t.Errorf("dotQuote(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWriteDOTLabels(t *testing.T) {
+	graph := map[string][]string{
+		"https://example.com/":            {`https://example.com/"q"`, "https://example.com/a-long-path"},
+		"https://example.com/a-long-path": {"https://example.com/"},
+		`https://example.com/"q"`:         nil,
+	}
+	var b strings.Builder
+	if err := WriteDOTLabels(&b, graph, 22); err != nil {
+		t.Fatal(err)
+	}
+	want := `digraph links {
+	node [shape=box];
+	"https://example.com/" [label="https://example.com/"];
+	"https://example.com/\"q\"" [label="https://example.com/\"…"];
+	"https://example.com/a-long-path" [label="https://example.com/a…"];
+	"https://example.com/" -> "https://example.com/\"q\"";
+	"https://example.com/" -> "https://example.com/a-long-path";
+	"https://example.com/a-long-path" -> "https://example.com/";
+}
+`
+	if b.String() != want {
+		t.Errorf("WriteDOTLabels wrote\n%s\nwant\n%s", b.String(), want)
+	}
+}
@@ -0,0 +1,545 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/proxy"
+	"golang.org/x/net/publicsuffix"
+)
+
+// StatusError is returned by HTTPFetcher for non-2xx responses.
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("fetch %s: %d %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// HTTPFetcher is a Fetcher that GETs pages over HTTP and
+// returns the links found in their HTML.
+type HTTPFetcher struct {
+	// Client performs the requests. If nil, a client is built from the
+	// transport settings below. Its CheckRedirect policy is replaced
+	// by MaxRedirects; everything else, including the transport, is used
+	// as it is.
+	Client *http.Client
+	// Proxy is the URL of the HTTP or HTTPS proxy to send requests
+	// through, optionally with user:password credentials. If empty, the
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are
+	// honored. Ignored if Client is set.
+	Proxy string
+	// SOCKS5Proxy is the address of a SOCKS5 proxy to open every
+	// connection through, such as the local end of an SSH tunnel, as
+	// host:port or as a socks5:// URL, which can carry user:password
+	// credentials. It can't be combined with Proxy; the environment
+	// variables are then ignored. Ignored if Client is set.
+	SOCKS5Proxy string
+	// UserAgent is sent as the User-Agent header. If empty, a
+	// User-Agent set in Headers is used, and failing that
+	// DefaultUserAgent.
+	UserAgent string
+	// Headers are added to every request.
+	Headers http.Header
+	// BareHostScheme, if set, makes links that look like a host and a
+	// path without a scheme, such as example.com/x, absolute URLs with
+	// this scheme, typically "https", instead of paths relative to the
+	// page. Browsers take them as relative, but pages written by hand
+	// often mean them as absolute. Protocol-relative links such as
+	// //example.com/x always take the scheme of the page.
+	BareHostScheme string
+	// KeepHeaders names the response headers kept in Page.Headers. Nil
+	// means DefaultKeepHeaders and an empty slice keeps none; the others
+	// are dropped, so that the cache doesn't fill up with them.
+	KeepHeaders []string
+	// Username and Password, if Username is set, are sent with HTTP
+	// basic authentication to the URLs AuthScope allows, and to no others:
+	// with a nil AuthScope they are not sent at all. The check is repeated
+	// for every redirect, so the credentials don't follow a redirect out
+	// of scope.
+	Username  string
+	Password  string
+	AuthScope *HostScope
+	// MaxRedirects is the number of redirects followed before a fetch
+	// fails with ErrTooManyRedirects. Zero means the default of 10, and
+	// a negative value rejects every redirect, failing the fetch with
+	// ErrTooManyRedirects rather than returning the 3xx response.
+	MaxRedirects int
+	// MaxBodyBytes caps how much of a response body is read. A response
+	// whose Content-Length exceeds it is not read at all, and one without
+	// Content-Length is read up to the cap; either way the page is
+	// returned marked as truncated rather than failing. Zero means
+	// DefaultMaxBodyBytes and a negative value means no cap.
+	MaxBodyBytes int64
+	// Jar stores the cookies set by responses and sends them back on
+	// later requests, so a session survives across the pages of a crawl.
+	// If nil, an empty jar is created on first use. Ignored if Client is
+	// set.
+	Jar http.CookieJar
+	// MaxConnsPerHost caps the connections open to one host at a time,
+	// whether in use or idle; requests beyond it wait for a connection
+	// to come free. MaxIdleConnsPerHost caps the idle connections kept
+	// for reuse, and is itself capped by MaxConnsPerHost. Zero means
+	// DefaultMaxConnsPerHost for both and a negative value means no
+	// limit. Ignored if Client is set.
+	MaxConnsPerHost     int
+	MaxIdleConnsPerHost int
+	// InsecureSkipVerify turns off the verification of TLS certificates,
+	// for internal sites with self-signed ones. Anyone on the network
+	// path can then impersonate the sites, so it is only for hosts that
+	// are trusted anyway, and a warning is logged when the client is
+	// built. Ignored if Client is set.
+	InsecureSkipVerify bool
+	// Logger receives the warning about InsecureSkipVerify. If nil,
+	// slog.Default() does.
+	Logger *slog.Logger
+	// Timeout bounds a single request, including reading the body.
+	// A request that runs out of time fails with an error wrapping
+	// context.DeadlineExceeded. Zero means no timeout.
+	Timeout time.Duration
+
+	// The client built from the settings above on first use.
+	initOnce  sync.Once
+	client    *http.Client
+	clientErr error
+}
+
+// DefaultKeepHeaders are the response headers HTTPFetcher keeps by
+// default: those telling what a page is and how fresh.
+var DefaultKeepHeaders = []string{"Content-Type", "Last-Modified", "ETag", "Cache-Control"}
+
+// DefaultUserAgent identifies the crawler to the sites it visits.
+const DefaultUserAgent = "crawler/1.0 (+https://github.com/maciekzieba/crawler)"
+
+// ErrTooManyRedirects is returned by HTTPFetcher when a page redirects
+// more than MaxRedirects times.
+var ErrTooManyRedirects = errors.New("too many redirects")
+
+const defaultMaxRedirects = 10
+
+// errFetchTimeout is the cause of a fetch's context running out of time
+// because of HTTPFetcher.Timeout.
+var errFetchTimeout = errors.New("fetch timeout")
+
+// DefaultMaxBodyBytes is the default for HTTPFetcher.MaxBodyBytes.
+const DefaultMaxBodyBytes = 10 << 20
+
+// DefaultMaxConnsPerHost is the default for HTTPFetcher.MaxConnsPerHost
+// and MaxIdleConnsPerHost: few enough not to burden a small server.
+const DefaultMaxConnsPerHost = 4
+
+// NewHTTPFetcher returns an HTTPFetcher sending its requests with client,
+// which lets callers tune connection pooling and TLS, wrap the transport,
+// or use the client of an httptest.Server. A nil client means one built
+// on first use from a copy of http.DefaultTransport, with the fetcher's
+// Proxy, Jar and connection limits applied.
+func NewHTTPFetcher(client *http.Client) *HTTPFetcher {
+	return &HTTPFetcher{Client: client}
+}
+
+func (f *HTTPFetcher) Fetch(rawURL string) (string, []string, error) {
+	page, err := f.FetchContext(context.Background(), rawURL)
+	if err != nil {
+		return "", nil, err
+	}
+	return page.Body, page.Links, nil
+}
+
+func (f *HTTPFetcher) FetchPage(rawURL string) (*Page, error) {
+	return f.FetchContext(context.Background(), rawURL)
+}
+
+// FetchContext fetches rawURL, following redirects, and resolves the
+// links in the page against the URL it was finally served from. Links
+// are only extracted from HTML; other text is returned without links,
+// and binary content is not read at all. The request is cancelled when
+// ctx is done. If ctx carries Validators for rawURL the request is
+// conditional, and a 304 Not Modified fails with ErrNotModified.
+func (f *HTTPFetcher) FetchContext(ctx context.Context, rawURL string) (*Page, error) {
+	if f.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, f.Timeout, errFetchTimeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range f.Headers {
+		req.Header[http.CanonicalHeaderKey(key)] = append([]string(nil), values...)
+	}
+	switch {
+	case f.UserAgent != "":
+		req.Header.Set("User-Agent", f.UserAgent)
+	case req.Header.Get("User-Agent") == "":
+		req.Header.Set("User-Agent", DefaultUserAgent)
+	}
+	f.setAuth(req)
+	v, conditional := ValidatorsFor(ctx, rawURL)
+	if conditional {
+		if v.ETag != "" {
+			req.Header.Set("If-None-Match", v.ETag)
+		}
+		if v.LastModified != "" {
+			req.Header.Set("If-Modified-Since", v.LastModified)
+		}
+	}
+
+	client, err := f.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	c := *client
+	c.CheckRedirect = f.checkRedirect
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, f.timeoutError(ctx, rawURL, err)
+	}
+	defer resp.Body.Close()
+
+	final := resp.Request.URL.String()
+	if conditional && resp.StatusCode == http.StatusNotModified {
+		return nil, fmt.Errorf("fetch %s: %w", rawURL, ErrNotModified)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &StatusError{URL: final, StatusCode: resp.StatusCode}
+	}
+
+	page := &Page{
+		URL:          final,
+		StatusCode:   resp.StatusCode,
+		Headers:      f.keepHeaders(resp.Header),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	mediaType := contentType(resp.Header)
+	if !isText(mediaType) {
+		return page, nil
+	}
+	max := f.MaxBodyBytes
+	if max == 0 {
+		max = DefaultMaxBodyBytes
+	}
+	if max > 0 && resp.ContentLength > max {
+		// Not worth downloading even in part.
+		page.Truncated = true
+		return page, nil
+	}
+	r, err := decodeBody(resp)
+	if err != nil {
+		return nil, fmt.Errorf("fetch %s: %w", rawURL, err)
+	}
+	if max > 0 {
+		// Read one byte more than allowed to tell whether there was more.
+		r = io.LimitReader(r, max+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, f.timeoutError(ctx, rawURL, fmt.Errorf("fetch %s: %w", rawURL, err))
+	}
+	page.Truncated = max > 0 && int64(len(data)) > max
+	if page.Truncated {
+		data = data[:max]
+	}
+	body := string(data)
+	page.Body = body
+	if isHTML(mediaType) {
+		doc := parseHTML(body, final, f.BareHostScheme)
+		page.Links, page.Canonical = doc.links, doc.canonical
+		page.NoIndex, page.NoFollow = doc.noindex, doc.nofollow
+	}
+	return page, nil
+}
+
+// keepHeaders returns the values in header of the headers named in
+// KeepHeaders, or nil if there are none.
+func (f *HTTPFetcher) keepHeaders(header http.Header) map[string]string {
+	names := f.KeepHeaders
+	if names == nil {
+		names = DefaultKeepHeaders
+	}
+	var kept map[string]string
+	for _, name := range names {
+		name = http.CanonicalHeaderKey(name)
+		if values := header.Values(name); len(values) > 0 {
+			if kept == nil {
+				kept = make(map[string]string, len(names))
+			}
+			kept[name] = strings.Join(values, ", ")
+		}
+	}
+	return kept
+}
+
+// decodeBody returns the body of resp decoded according to its
+// Content-Encoding. The transport already decompresses gzip when it asked
+// for it itself, but not when Accept-Encoding was set by hand, as it may
+// be through Headers.
+func decodeBody(resp *http.Response) (io.Reader, error) {
+	if resp.Uncompressed {
+		return resp.Body, nil
+	}
+	switch encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		return resp.Body, nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(resp.Body)
+	case "deflate":
+		// Deflate is meant to be zlib-wrapped, but some servers send
+		// raw deflate data.
+		br := bufio.NewReader(resp.Body)
+		if header, err := br.Peek(2); err == nil && isZlibHeader(header) {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+}
+
+// isZlibHeader reports whether b starts a zlib stream: deflate with a
+// window of at most 32KB and a header checksum that adds up.
+func isZlibHeader(b []byte) bool {
+	return b[0]&0x0f == 8 && b[0]>>4 <= 7 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0
+}
+
+// Close closes the idle connections of the fetcher's client. The fetcher
+// can still be used afterwards, opening new connections as needed.
+func (f *HTTPFetcher) Close() error {
+	client := f.Client
+	if client == nil {
+		client = f.client
+	}
+	if client != nil {
+		client.CloseIdleConnections()
+	}
+	return nil
+}
+
+// contentType returns the media type of a response, lower-cased and
+// without parameters. A missing Content-Type is taken to be HTML.
+func contentType(h http.Header) string {
+	value := h.Get("Content-Type")
+	if value == "" {
+		return "text/html"
+	}
+	mediaType, _, err := mime.ParseMediaType(value)
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+func isHTML(mediaType string) bool {
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// isText reports whether mediaType is textual and worth reading: HTML,
+// but also plain text such as robots.txt and XML such as sitemaps.
+func isText(mediaType string) bool {
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+xml"),
+		strings.HasSuffix(mediaType, "+json"),
+		mediaType == "application/xml",
+		mediaType == "application/json":
+		return true
+	}
+	return false
+}
+
+// httpClient returns f.Client, or else the client built from f's
+// settings, building it the first time it is needed.
+func (f *HTTPFetcher) httpClient() (*http.Client, error) {
+	if f.Client != nil {
+		return f.Client, nil
+	}
+	f.initOnce.Do(func() {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyFromEnvironment
+		f.limitConns(transport)
+		if f.InsecureSkipVerify {
+			if transport.TLSClientConfig == nil {
+				transport.TLSClientConfig = &tls.Config{}
+			}
+			transport.TLSClientConfig.InsecureSkipVerify = true
+			logger := f.Logger
+			if logger == nil {
+				logger = slog.Default()
+			}
+			logger.Warn("TLS certificate verification is disabled; connections can be intercepted")
+		}
+		if f.SOCKS5Proxy != "" {
+			if f.Proxy != "" {
+				f.clientErr = errors.New("both an HTTP proxy and a SOCKS5 proxy are set")
+				return
+			}
+			dialer, err := socks5Dialer(f.SOCKS5Proxy)
+			if err != nil {
+				f.clientErr = err
+				return
+			}
+			transport.Proxy = nil
+			transport.DialContext = dialer.DialContext
+		}
+		if f.Proxy != "" {
+			proxyURL, err := url.Parse(f.Proxy)
+			if err != nil {
+				f.clientErr = fmt.Errorf("invalid proxy %q: %w", f.Proxy, err)
+				return
+			}
+			transport.Proxy = http.ProxyURL(proxyURL)
+		}
+		jar := f.Jar
+		if jar == nil {
+			// cookiejar.New only fails on invalid options.
+			jar, _ = cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+		}
+		f.client = &http.Client{Transport: transport, Jar: jar}
+	})
+	return f.client, f.clientErr
+}
+
+// socks5Dialer returns a dialer connecting through the SOCKS5 proxy at
+// addr, given as host:port or as a socks5:// URL with optional
+// credentials.
+func socks5Dialer(addr string) (proxy.ContextDialer, error) {
+	var auth *proxy.Auth
+	if strings.Contains(addr, "://") {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SOCKS5 proxy %q: %w", addr, err)
+		}
+		if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+			return nil, fmt.Errorf("invalid SOCKS5 proxy %q: scheme must be socks5", addr)
+		}
+		if u.User != nil {
+			password, _ := u.User.Password()
+			auth = &proxy.Auth{User: u.User.Username(), Password: password}
+		}
+		addr = u.Host
+	}
+	dialer, err := proxy.SOCKS5("tcp", addr, auth, &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("invalid SOCKS5 proxy %q: %w", addr, err)
+	}
+	// The SOCKS5 dialer of x/net/proxy supports contexts.
+	return dialer.(proxy.ContextDialer), nil
+}
+
+// limitConns applies MaxConnsPerHost and MaxIdleConnsPerHost to
+// transport, whose zero values mean no limit and a default of two idle
+// connections respectively.
+func (f *HTTPFetcher) limitConns(transport *http.Transport) {
+	conns, idle := f.MaxConnsPerHost, f.MaxIdleConnsPerHost
+	switch {
+	case conns == 0:
+		conns = DefaultMaxConnsPerHost
+	case conns < 0:
+		conns = 0
+	}
+	switch {
+	case idle == 0:
+		idle = DefaultMaxConnsPerHost
+	case idle < 0:
+		idle = transport.MaxIdleConns
+	}
+	if conns > 0 {
+		idle = min(idle, conns)
+	}
+	transport.MaxConnsPerHost = conns
+	transport.MaxIdleConnsPerHost = idle
+}
+
+func (f *HTTPFetcher) checkRedirect(req *http.Request, via []*http.Request) error {
+	max := f.MaxRedirects
+	if max == 0 {
+		max = defaultMaxRedirects
+	}
+	if len(via) > max {
+		return ErrTooManyRedirects
+	}
+	// The client copies the headers of the original request, an
+	// Authorization set in Headers included, so the credentials have
+	// to be checked against the new URL.
+	if !f.keepsAuth(req, via[0]) {
+		req.Header.Del("Authorization")
+	}
+	f.setAuth(req)
+	return nil
+}
+
+// keepsAuth reports whether the Authorization header of the original
+// request may go on to req, a redirect: if req's URL is within
+// AuthScope, or, without one, on the original request's host.
+func (f *HTTPFetcher) keepsAuth(req, original *http.Request) bool {
+	if f.AuthScope != nil {
+		return f.AuthScope.Allows(req.URL.String())
+	}
+	return strings.EqualFold(req.URL.Host, original.URL.Host)
+}
+
+// setAuth adds the basic authentication credentials to req if its URL
+// is within AuthScope.
+func (f *HTTPFetcher) setAuth(req *http.Request) {
+	if f.Username != "" && f.AuthScope != nil && f.AuthScope.Allows(req.URL.String()) {
+		req.SetBasicAuth(f.Username, f.Password)
+	}
+}
+
+// timeoutError replaces err with a timeout error if ctx ran out of time
+// because of f.Timeout, and with the error of ctx if it is done
+// otherwise.
+func (f *HTTPFetcher) timeoutError(ctx context.Context, rawURL string, err error) error {
+	switch {
+	case ctx.Err() == nil:
+		return err
+	case context.Cause(ctx) == errFetchTimeout:
+		return fmt.Errorf("fetch %s: timed out after %s: %w", rawURL, f.Timeout, context.DeadlineExceeded)
+	default:
+		return fmt.Errorf("fetch %s: %w", rawURL, ctx.Err())
+	}
+}
+
+// cookieList is a flag.Value collecting the cookies of repeated flags,
+// each in the form of a Cookie header such as "session=abc; theme=dark".
+type cookieList []*http.Cookie
+
+func (c *cookieList) String() string {
+	if c == nil {
+		return ""
+	}
+	pairs := make([]string, len(*c))
+	for i, cookie := range *c {
+		pairs[i] = cookie.String()
+	}
+	return strings.Join(pairs, "; ")
+}
+
+func (c *cookieList) Set(value string) error {
+	cookies, err := http.ParseCookie(value)
+	if err != nil {
+		return err
+	}
+	*c = append(*c, cookies...)
+	return nil
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const compressedPage = `<html><body><a href="/next">next</a></body></html>`
+
+// compress encodes body with the named Content-Encoding.
+func compress(t *testing.T, encoding, body string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw-deflate":
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	default:
+		t.Fatalf("unknown encoding %q", encoding)
+	}
+	if _, err := io.WriteString(w, body); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// compressingServer serves compressedPage encoded as encoding to clients
+// that accept it, and fails the test for any other client.
+func compressingServer(t *testing.T, encoding string) *httptest.Server {
+	t.Helper()
+	header := encoding
+	if encoding == "raw-deflate" {
+		header = "deflate"
+	}
+	body := compress(t, encoding, compressedPage)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), header) {
+			t.Errorf("Accept-Encoding = %q, want it to include %q", r.Header.Get("Accept-Encoding"), header)
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Encoding", header)
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func checkDecoded(t *testing.T, f *HTTPFetcher, srv *httptest.Server) {
+	t.Helper()
+	body, urls, err := f.Fetch(srv.URL + "/")
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if body != compressedPage {
+		t.Errorf("body = %q, want %q", body, compressedPage)
+	}
+	if want := []string{srv.URL + "/next"}; !slices.Equal(urls, want) {
+		t.Errorf("links = %q, want %q", urls, want)
+	}
+}
+
+func TestHTTPFetcherGzipTransport(t *testing.T) {
+	srv := compressingServer(t, "gzip")
+	checkDecoded(t, &HTTPFetcher{}, srv)
+}
+
+func TestHTTPFetcherGzipManualHeader(t *testing.T) {
+	srv := compressingServer(t, "gzip")
+	f := &HTTPFetcher{Headers: http.Header{"Accept-Encoding": {"gzip, deflate"}}}
+	checkDecoded(t, f, srv)
+}
+
+func TestHTTPFetcherDeflate(t *testing.T) {
+	for _, encoding := range []string{"deflate", "raw-deflate"} {
+		t.Run(encoding, func(t *testing.T) {
+			srv := compressingServer(t, encoding)
+			f := &HTTPFetcher{Headers: http.Header{"Accept-Encoding": {"deflate"}}}
+			checkDecoded(t, f, srv)
+		})
+	}
+}
+
+func TestHTTPFetcherUnsupportedEncoding(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "br")
+		io.WriteString(w, "not really brotli")
+	}))
+	defer srv.Close()
+	f := &HTTPFetcher{Headers: http.Header{"Accept-Encoding": {"br"}}}
+	if _, _, err := f.Fetch(srv.URL + "/"); err == nil || !strings.Contains(err.Error(), "unsupported content encoding") {
+		t.Errorf("Fetch error = %v, want unsupported content encoding", err)
+	}
+}
+
+func TestNewHTTPFetcherClient(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, compressedPage)
+	}))
+	defer srv.Close()
+
+	// The server's certificate is only trusted by its own client.
+	if _, _, err := NewHTTPFetcher(nil).Fetch(srv.URL + "/"); err == nil {
+		t.Error("Fetch with the default client succeeded, want a certificate error")
+	}
+	body, _, err := NewHTTPFetcher(srv.Client()).Fetch(srv.URL + "/")
+	if err != nil {
+		t.Fatalf("Fetch with the server's client: %v", err)
+	}
+	if body != compressedPage {
+		t.Errorf("body = %q, want %q", body, compressedPage)
+	}
+}
+
+func TestHTTPFetcherBasicAuthScope(t *testing.T) {
+	var offHostAuth string
+	offHost := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		offHostAuth = r.Header.Get("Authorization")
+		io.WriteString(w, "off host")
+	}))
+	defer offHost.Close()
+	protected := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/away" {
+			http.Redirect(w, r, offHost.URL+"/", http.StatusFound)
+			return
+		}
+		if user, password, ok := r.BasicAuth(); !ok || user != "alice" || password != "secret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="staging"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, "protected")
+	}))
+	defer protected.Close()
+
+	scope, err := NewHostScope(protected.URL, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &HTTPFetcher{Username: "alice", Password: "secret", AuthScope: scope}
+	if body, _, err := f.Fetch(protected.URL + "/"); err != nil || body != "protected" {
+		t.Errorf("Fetch of the protected page = %q, %v; want it authorized", body, err)
+	}
+
+	// The test servers differ only in port, which counts as another host.
+	for _, url := range []string{offHost.URL + "/", protected.URL + "/away"} {
+		offHostAuth = ""
+		if _, _, err := f.Fetch(url); err != nil {
+			t.Fatalf("Fetch(%q): %v", url, err)
+		}
+		if offHostAuth != "" {
+			t.Errorf("Fetch(%q) sent Authorization %q to another host", url, offHostAuth)
+		}
+	}
+
+	if _, _, err := (&HTTPFetcher{Username: "alice", Password: "secret"}).Fetch(protected.URL + "/"); err == nil {
+		t.Error("Fetch without an AuthScope succeeded, want the credentials withheld")
+	}
+}
+
+// socks5Stub is a SOCKS5 proxy accepting CONNECT requests, with
+// username/password authentication if user is set, and recording the
+// addresses it connected to.
+type socks5Stub struct {
+	user, password string
+	mux            sync.Mutex
+	targets        []string
+}
+
+// serve runs the proxy on a local listener until the test ends and
+// returns its address.
+func (p *socks5Stub) serve(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go p.handle(conn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func (p *socks5Stub) handle(conn net.Conn) {
+	defer conn.Close()
+	// Greeting: version, then the authentication methods offered.
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(conn, hdr); err != nil || hdr[0] != 5 {
+		return
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+	method := byte(0) // no authentication
+	if p.user != "" {
+		method = 2 // username/password
+	}
+	if !bytes.Contains(methods, []byte{method}) {
+		conn.Write([]byte{5, 0xff})
+		return
+	}
+	conn.Write([]byte{5, method})
+	if method == 2 {
+		user, password, ok := readSOCKSCredentials(conn)
+		if !ok || user != p.user || password != p.password {
+			conn.Write([]byte{1, 1})
+			return
+		}
+		conn.Write([]byte{1, 0})
+	}
+	// Request: version, CONNECT, reserved, then the address.
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(conn, req); err != nil || req[1] != 1 {
+		return
+	}
+	var host string
+	switch req[3] {
+	case 1:
+		ip := make([]byte, 4)
+		io.ReadFull(conn, ip)
+		host = net.IP(ip).String()
+	case 3:
+		n := make([]byte, 1)
+		io.ReadFull(conn, n)
+		name := make([]byte, n[0])
+		io.ReadFull(conn, name)
+		host = string(name)
+	default:
+		return
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return
+	}
+	target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+	p.mux.Lock()
+	p.targets = append(p.targets, target)
+	p.mux.Unlock()
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go io.Copy(upstream, conn)
+	io.Copy(conn, upstream)
+}
+
+// readSOCKSCredentials reads a username/password subnegotiation.
+func readSOCKSCredentials(r io.Reader) (user, password string, ok bool) {
+	field := func() (string, bool) {
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(r, n); err != nil {
+			return "", false
+		}
+		b := make([]byte, n[0])
+		_, err := io.ReadFull(r, b)
+		return string(b), err == nil
+	}
+	version := make([]byte, 1)
+	if _, err := io.ReadFull(r, version); err != nil || version[0] != 1 {
+		return "", "", false
+	}
+	if user, ok = field(); !ok {
+		return "", "", false
+	}
+	password, ok = field()
+	return user, password, ok
+}
+
+func TestHTTPFetcherSOCKS5Proxy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, compressedPage)
+	}))
+	defer srv.Close()
+	socks := &socks5Stub{user: "tunnel", password: "secret"}
+	addr := socks.serve(t)
+
+	f := &HTTPFetcher{SOCKS5Proxy: "socks5://tunnel:secret@" + addr}
+	body, _, err := f.Fetch(srv.URL + "/")
+	if err != nil {
+		t.Fatalf("Fetch through the proxy: %v", err)
+	}
+	if body != compressedPage {
+		t.Errorf("body = %q, want %q", body, compressedPage)
+	}
+	socks.mux.Lock()
+	targets := socks.targets
+	socks.mux.Unlock()
+	if want := []string{srv.Listener.Addr().String()}; !slices.Equal(targets, want) {
+		t.Errorf("proxy connected to %q, want %q", targets, want)
+	}
+
+	if _, _, err := (&HTTPFetcher{SOCKS5Proxy: "socks5://tunnel:wrong@" + addr}).Fetch(srv.URL + "/"); err == nil {
+		t.Error("Fetch with wrong proxy credentials succeeded")
+	}
+	if _, _, err := (&HTTPFetcher{SOCKS5Proxy: addr, Proxy: "http://127.0.0.1:1"}).Fetch(srv.URL + "/"); err == nil {
+		t.Error("Fetch with both a SOCKS5 and an HTTP proxy succeeded")
+	}
+}
+
+func TestHTTPFetcherRedirectAuthorizationHeader(t *testing.T) {
+	var offHostAuth string
+	offHost := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		offHostAuth = r.Header.Get("Authorization")
+		io.WriteString(w, "off host")
+	}))
+	defer offHost.Close()
+	protected := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+			return
+		case "/away":
+			http.Redirect(w, r, offHost.URL+"/", http.StatusFound)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, "protected")
+	}))
+	defer protected.Close()
+
+	f := &HTTPFetcher{Headers: http.Header{"Authorization": {"Bearer token"}}}
+	if body, _, err := f.Fetch(protected.URL + "/old"); err != nil || body != "protected" {
+		t.Errorf("Fetch through a same-host redirect = %q, %v; want the Authorization header kept", body, err)
+	}
+	if _, _, err := f.Fetch(protected.URL + "/away"); err != nil {
+		t.Fatalf("Fetch through an off-host redirect: %v", err)
+	}
+	if offHostAuth != "" {
+		t.Errorf("off-host redirect sent Authorization %q", offHostAuth)
+	}
+
+	scope, err := NewHostScope(protected.URL, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.AuthScope = scope
+	if body, _, err := f.Fetch(protected.URL + "/old"); err != nil || body != "protected" {
+		t.Errorf("Fetch through a redirect within AuthScope = %q, %v; want the Authorization header kept", body, err)
+	}
+}
+
+func TestHTTPFetcherTimeoutError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	wait := 20 * time.Millisecond
+
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		ctx     time.Duration
+		want    string
+	}{
+		{"fetch timeout", wait, time.Minute, "timed out after " + wait.String()},
+		{"context deadline", 0, wait, "fetch " + srv.URL + "/: context deadline exceeded"},
+		{"context deadline within fetch timeout", time.Minute, wait, "fetch " + srv.URL + "/: context deadline exceeded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), tt.ctx)
+			defer cancel()
+			f := &HTTPFetcher{Timeout: tt.timeout}
+			_, err := f.FetchContext(ctx, srv.URL+"/")
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("FetchContext error = %v, want context.DeadlineExceeded", err)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("FetchContext error = %q, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPFetcherMaxRedirects(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/")); n > 0 {
+			http.Redirect(w, r, "/"+strconv.Itoa(n-1), http.StatusFound)
+			return
+		}
+		io.WriteString(w, "landed")
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		max, redirects int
+		wantErr        bool
+	}{
+		{0, 10, false},
+		{0, 11, true},
+		{2, 2, false},
+		{2, 3, true},
+		{-1, 0, false},
+		{-1, 1, true},
+	}
+	for _, tt := range tests {
+		f := &HTTPFetcher{MaxRedirects: tt.max}
+		body, _, err := f.Fetch(srv.URL + "/" + strconv.Itoa(tt.redirects))
+		if tt.wantErr && !errors.Is(err, ErrTooManyRedirects) {
+			t.Errorf("MaxRedirects %d, %d redirects: err = %v, want ErrTooManyRedirects", tt.max, tt.redirects, err)
+		}
+		if !tt.wantErr && (err != nil || body != "landed") {
+			t.Errorf("MaxRedirects %d, %d redirects: Fetch = %q, %v; want the final page", tt.max, tt.redirects, body, err)
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/publicsuffix"
+)
+
+// htmlPage is what parseHTML finds in a page.
+type htmlPage struct {
+	links []string
+	// canonical is the URL of the first <link rel="canonical">, if any.
+	canonical string
+	// noindex and nofollow are the directives of the page's
+	// <meta name="robots"> tags.
+	noindex, nofollow bool
+}
+
+// parseHTML tokenizes the HTML in body and returns the href targets of
+// its <a> tags, its canonical link, resolved against base, and its meta
+// robots directives. Fragments are stripped, links that don't point to
+// an http or https page (fragment-only, javascript:, mailto: and the
+// like) or are marked rel="nofollow" are dropped, and the links are
+// de-duplicated in document order. bareHostScheme is passed on to
+// resolveLink.
+//
+// As in browsers, the first <base href> of the page, itself resolved
+// against base, takes the place of base for every link of the page,
+// those before it included.
+func parseHTML(body string, base string, bareHostScheme string) htmlPage {
+	var page htmlPage
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return page
+	}
+
+	// The links are resolved once the whole page has been read, for a
+	// <base> to apply to those before it.
+	var hrefs, canonicals []string
+	hasBase := false
+	z := html.NewTokenizer(strings.NewReader(body))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			seen := make(map[string]bool)
+			for _, href := range hrefs {
+				link, ok := resolveLink(baseURL, href, bareHostScheme)
+				if ok && !seen[link] {
+					seen[link] = true
+					page.links = append(page.links, link)
+				}
+			}
+			for _, href := range canonicals {
+				if link, ok := resolveLink(baseURL, href, bareHostScheme); ok {
+					page.canonical = link
+					break
+				}
+			}
+			return page
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch string(name) {
+			case "a":
+				attrs := tagAttrs(z, hasAttr)
+				if hasToken(attrs["rel"], "nofollow") {
+					continue
+				}
+				hrefs = append(hrefs, attrs["href"])
+			case "base":
+				attrs := tagAttrs(z, hasAttr)
+				href, ok := attrs["href"]
+				if hasBase || !ok {
+					continue
+				}
+				// Only the first <base href> counts, even if it is
+				// unusable, when the page URL stays the base.
+				hasBase = true
+				if link, ok := resolveLink(baseURL, href, ""); ok {
+					if u, err := url.Parse(link); err == nil {
+						baseURL = u
+					}
+				}
+			case "link":
+				attrs := tagAttrs(z, hasAttr)
+				if hasToken(attrs["rel"], "canonical") {
+					canonicals = append(canonicals, attrs["href"])
+				}
+			case "meta":
+				attrs := tagAttrs(z, hasAttr)
+				if !strings.EqualFold(attrs["name"], "robots") {
+					continue
+				}
+				for _, directive := range strings.Split(attrs["content"], ",") {
+					switch strings.ToLower(strings.TrimSpace(directive)) {
+					case "noindex":
+						page.noindex = true
+					case "nofollow":
+						page.nofollow = true
+					case "none":
+						page.noindex, page.nofollow = true, true
+					}
+				}
+			}
+		}
+	}
+}
+
+// tagAttrs returns the attributes of the tag z is at, keeping the first
+// of any repeated attribute as browsers do.
+func tagAttrs(z *html.Tokenizer, hasAttr bool) map[string]string {
+	attrs := make(map[string]string)
+	for hasAttr {
+		var key, val []byte
+		key, val, hasAttr = z.TagAttr()
+		if _, ok := attrs[string(key)]; !ok {
+			attrs[string(key)] = string(val)
+		}
+	}
+	return attrs
+}
+
+// hasToken reports whether the space-separated list of an attribute
+// such as rel contains token, ignoring case.
+func hasToken(list, token string) bool {
+	for _, t := range strings.Fields(list) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveLink resolves href against base, reporting false for links
+// that should not be followed. A protocol-relative href such as
+// //cdn.example.com/x takes the scheme of base. If bareHostScheme is
+// set, an href that looks like a host and a path, such as
+// example.com/x, is taken as an absolute URL with that scheme rather
+// than as a path relative to base; see isBareHost.
+func resolveLink(base *url.URL, href string, bareHostScheme string) (string, bool) {
+	href = strings.TrimSpace(href)
+	if href == "" || strings.HasPrefix(href, "#") {
+		return "", false
+	}
+	if bareHostScheme != "" && isBareHost(href) {
+		href = bareHostScheme + "://" + href
+	}
+	ref, err := url.Parse(href)
+	if err != nil {
+		return "", false
+	}
+	u := base.ResolveReference(ref)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", false
+	}
+	u.Fragment = ""
+	return u.String(), true
+}
+
+// isBareHost reports whether href looks like a URL missing its scheme
+// rather than a relative path: its first segment is a host name under a
+// public suffix, like example.com, optionally with a port, and it is
+// either followed by a path or starts with "www.". That leaves out
+// relative links to files such as page.html, but also a bare
+// "example.com", which can't be told apart from a file of that name.
+func isBareHost(href string) bool {
+	if strings.HasPrefix(href, "/") || strings.HasPrefix(href, ".") {
+		return false
+	}
+	host, rest, _ := strings.Cut(href, "/")
+	host, _, _ = strings.Cut(host, "?")
+	host, _, _ = strings.Cut(host, "#")
+	if port := strings.LastIndexByte(host, ':'); port >= 0 {
+		if _, err := strconv.ParseUint(host[port+1:], 10, 16); err != nil {
+			// A colon not followed by a port number belongs to a
+			// scheme, as in mailto:, or to a path.
+			return false
+		}
+		host = host[:port]
+	}
+	if !strings.Contains(host, ".") || strings.ContainsAny(host, " %@") {
+		return false
+	}
+	if rest == "" && !strings.HasPrefix(strings.ToLower(host), "www.") {
+		return false
+	}
+	suffix, icann := publicsuffix.PublicSuffix(strings.ToLower(host))
+	return icann && len(suffix) < len(host)
+}
+
+// withDefaultScheme returns raw prefixed with scheme if it has no
+// scheme of its own, as in example.com/x or //example.com/x, for URLs
+// typed by hand such as seeds.
+func withDefaultScheme(raw, scheme string) string {
+	switch {
+	case scheme == "" || strings.Contains(raw, "://"):
+		return raw
+	case strings.HasPrefix(raw, "//"):
+		return scheme + ":" + raw
+	default:
+		return scheme + "://" + raw
+	}
+}
+
+// normalizeURL returns the canonical form of raw used to decide whether
+// two URLs name the same page: the scheme and host are lower-cased, a
+// default port is removed, a trailing slash is stripped from the path
+// (the root path stays "/"), query parameters are sorted and the
+// fragment is dropped.
+func normalizeURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if port := u.Port(); (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		u.Host = u.Hostname()
+	}
+	if u.Path != "/" {
+		u.Path = strings.TrimRight(u.Path, "/")
+		u.RawPath = strings.TrimRight(u.RawPath, "/")
+	}
+	if u.Path == "" && u.Host != "" {
+		u.Path = "/"
+	}
+	u.RawQuery = u.Query().Encode()
+	u.ForceQuery = false
+	u.Fragment = ""
+	u.RawFragment = ""
+	return u.String(), nil
+}
+
+// DefaultStripParams are the tracking parameters Config.StripParams
+// removes by default.
+var DefaultStripParams = []string{"utm_*", "gclid", "fbclid", "ref"}
+
+// stripParams removes from the query of raw the parameters named in
+// params, where a name ending in "*" stands for every parameter with
+// that prefix. raw is returned unchanged if it has none of them or
+// can't be parsed.
+func stripParams(raw string, params []string) string {
+	if len(params) == 0 || !strings.Contains(raw, "?") {
+		return raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.RawQuery == "" {
+		return raw
+	}
+	query := u.Query()
+	stripped := false
+	for key := range query {
+		if matchParam(key, params) {
+			query.Del(key)
+			stripped = true
+		}
+	}
+	if !stripped {
+		return raw
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// matchParam reports whether the query parameter key is named in
+// params, where a name ending in "*" stands for every parameter with
+// that prefix.
+func matchParam(key string, params []string) bool {
+	for _, param := range params {
+		prefix, wildcard := strings.CutSuffix(param, "*")
+		if key == param || wildcard && strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// DedupMode is the policy deciding which URLs are taken for the same
+// page; see Config.Dedup. It is a flag.Value accepting "full", "path"
+// and "params".
+type DedupMode int
+
+const (
+	// FullURL tells URLs apart by their whole normalized form.
+	FullURL DedupMode = iota
+	// PathOnly ignores the query.
+	PathOnly
+	// PathPlusWhitelistedParams ignores the query parameters but those
+	// in Config.DedupParams.
+	PathPlusWhitelistedParams
+)
+
+var dedupModeNames = []string{"full", "path", "params"}
+
+func (m DedupMode) String() string {
+	if m < 0 || int(m) >= len(dedupModeNames) {
+		return fmt.Sprintf("DedupMode(%d)", int(m))
+	}
+	return dedupModeNames[m]
+}
+
+func (m *DedupMode) Set(name string) error {
+	i := slices.Index(dedupModeNames, name)
+	if i < 0 {
+		return fmt.Errorf("unknown dedup mode %q (want %s)", name, strings.Join(dedupModeNames, ", "))
+	}
+	*m = DedupMode(i)
+	return nil
+}
+
+// dedupURL returns the form of raw compared against the visited URLs
+// under mode: raw without its query for PathOnly, and with only the
+// query parameters in params for PathPlusWhitelistedParams. raw is
+// returned unchanged for FullURL or if it can't be parsed.
+func dedupURL(raw string, mode DedupMode, params []string) string {
+	if mode == FullURL || !strings.Contains(raw, "?") {
+		return raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	if mode == PathOnly {
+		u.RawQuery = ""
+		u.ForceQuery = false
+		return u.String()
+	}
+	query := u.Query()
+	for key := range query {
+		if !matchParam(key, params) {
+			query.Del(key)
+		}
+	}
+	u.RawQuery = query.Encode()
+	u.ForceQuery = false
+	return u.String()
+}
+
+// pathDepth returns the number of segments in the path of raw: 0 for
+// "/", 2 for both "/a/b" and "/a/b/", and 0 if raw can't be parsed.
+func pathDepth(raw string) int {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return 0
+	}
+	depth := 0
+	for _, segment := range strings.Split(u.EscapedPath(), "/") {
+		if segment != "" {
+			depth++
+		}
+	}
+	return depth
+}
+
+// urlKey returns the normalized form of raw, or raw itself if it can't
+// be parsed.
+func urlKey(raw string) string {
+	if key, err := normalizeURL(raw); err == nil {
+		return key
+	}
+	return raw
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestResolveLinkSchemes(t *testing.T) {
+	tests := []struct {
+		name, base, href, bareHostScheme string
+		want                             string
+		ok                               bool
+	}{
+		{"absolute", "https://example.com/a/", "http://other.example/x", "", "http://other.example/x", true},
+		{"absolute with bare hosts", "https://example.com/a/", "http://other.example/x", "https", "http://other.example/x", true},
+		{"protocol-relative over https", "https://example.com/a/", "//cdn.example.com/x", "", "https://cdn.example.com/x", true},
+		{"protocol-relative over http", "http://example.com/a/", "//cdn.example.com/x", "", "http://cdn.example.com/x", true},
+		{"protocol-relative with bare hosts", "http://example.com/a/", "//cdn.example.com/x", "https", "http://cdn.example.com/x", true},
+		{"bare host is relative by default", "https://example.com/a/", "cdn.example.com/x", "", "https://example.com/a/cdn.example.com/x", true},
+		{"bare host", "http://example.com/a/", "cdn.example.com/x", "https", "https://cdn.example.com/x", true},
+		{"bare host with port", "http://example.com/a/", "cdn.example.com:8443/x?y=1", "https", "https://cdn.example.com:8443/x?y=1", true},
+		{"bare www host", "http://example.com/a/", "www.example.org", "https", "https://www.example.org", true},
+		{"relative file", "https://example.com/a/", "page.html", "https", "https://example.com/a/page.html", true},
+		{"relative path", "https://example.com/a/", "docs/page.html", "https", "https://example.com/a/docs/page.html", true},
+		{"relative dotted directory", "https://example.com/a/", "v1.2/notes", "https", "https://example.com/a/v1.2/notes", true},
+		{"mailto", "https://example.com/a/", "mailto:someone@example.com", "https", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base, err := url.Parse(tt.base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := resolveLink(base, tt.href, tt.bareHostScheme)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("resolveLink(%q, %q, %q) = %q, %v; want %q, %v", tt.base, tt.href, tt.bareHostScheme, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestWithDefaultScheme(t *testing.T) {
+	tests := []struct{ raw, want string }{
+		{"example.com", "https://example.com"},
+		{"example.com/a/b", "https://example.com/a/b"},
+		{"//example.com/a", "https://example.com/a"},
+		{"localhost:8080/", "https://localhost:8080/"},
+		{"http://example.com/", "http://example.com/"},
+	}
+	for _, tt := range tests {
+		if got := withDefaultScheme(tt.raw, "https"); got != tt.want {
+			t.Errorf("withDefaultScheme(%q, \"https\") = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct{ raw, want string }{
+		{"http://example.com:80/a", "http://example.com/a"},
+		{"https://example.com:443/a", "https://example.com/a"},
+		{"https://example.com:80/a", "https://example.com:80/a"},
+		{"http://example.com:8080/a", "http://example.com:8080/a"},
+		{"https://example.com/a#top", "https://example.com/a"},
+		{"https://example.com/a?#", "https://example.com/a"},
+		{"https://example.com/a/", "https://example.com/a"},
+		{"https://example.com/a//", "https://example.com/a"},
+		{"https://example.com/", "https://example.com/"},
+		{"https://example.com", "https://example.com/"},
+		{"HTTPS://Example.COM/Path", "https://example.com/Path"},
+		{"https://example.com/a?b=2&a=1", "https://example.com/a?a=1&b=2"},
+		{"HTTP://Example.com:80/a/?z=1#frag", "http://example.com/a?z=1"},
+	}
+	for _, tt := range tests {
+		got, err := normalizeURL(tt.raw)
+		if err != nil || got != tt.want {
+			t.Errorf("normalizeURL(%q) = %q, %v; want %q", tt.raw, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseHTMLBase(t *testing.T) {
+	const page = "https://example.com/dir/page.html"
+	tests := []struct {
+		name, body string
+		links      []string
+		canonical  string
+	}{
+		{
+			"no base",
+			`<a href="a.html">a</a> <a href="/root">root</a>`,
+			[]string{"https://example.com/dir/a.html", "https://example.com/root"},
+			"",
+		},
+		{
+			"absolute base",
+			`<head><base href="https://cdn.example.org/static/"><link rel="canonical" href="canonical.html"></head>
+			<a href="a.html">a</a> <a href="/root">root</a> <a href="https://other.example/x">other</a>`,
+			[]string{"https://cdn.example.org/static/a.html", "https://cdn.example.org/root", "https://other.example/x"},
+			"https://cdn.example.org/static/canonical.html",
+		},
+		{
+			"relative base",
+			`<base href="../other/sub/"><a href="a.html">a</a> <a href="../b.html">b</a>`,
+			[]string{"https://example.com/other/sub/a.html", "https://example.com/other/b.html"},
+			"",
+		},
+		{
+			"root-relative base",
+			`<base href="/v2/"><a href="a.html">a</a>`,
+			[]string{"https://example.com/v2/a.html"},
+			"",
+		},
+		{
+			"base after links",
+			`<a href="a.html">a</a><base href="/v2/"><a href="b.html">b</a>`,
+			[]string{"https://example.com/v2/a.html", "https://example.com/v2/b.html"},
+			"",
+		},
+		{
+			"first base wins",
+			`<base target="_blank"><base href="/first/"><base href="/second/"><a href="a.html">a</a>`,
+			[]string{"https://example.com/first/a.html"},
+			"",
+		},
+		{
+			"unusable base",
+			`<base href="javascript:void(0)"><base href="/second/"><a href="a.html">a</a>`,
+			[]string{"https://example.com/dir/a.html"},
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseHTML(tt.body, page, "")
+			if !slices.Equal(got.links, tt.links) {
+				t.Errorf("links = %q, want %q", got.links, tt.links)
+			}
+			if got.canonical != tt.canonical {
+				t.Errorf("canonical = %q, want %q", got.canonical, tt.canonical)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import "maps"
+
+// SetMeta attaches value under key to url, for handlers to enrich the
+// crawl with what they make of its pages, such as tags classifying
+// them, without a field of CrawlResult for it. It can be called at any
+// time, from any goroutine: from a handler registered with Subscribe as
+// pages are fetched, or once the crawl is over. URLs naming the same
+// page, as normalizeURL tells, share their metadata, and it is kept
+// across the Crawler's runs.
+func (c *Crawler) SetMeta(url, key string, value any) {
+	c.metaMux.Lock()
+	defer c.metaMux.Unlock()
+	if c.meta == nil {
+		c.meta = make(map[string]map[string]any)
+	}
+	k := urlKey(url)
+	if c.meta[k] == nil {
+		c.meta[k] = make(map[string]any)
+	}
+	c.meta[k][key] = value
+}
+
+// GetMeta returns the value SetMeta attached under key to url, and
+// whether there is one.
+func (c *Crawler) GetMeta(url, key string) (any, bool) {
+	c.metaMux.RLock()
+	defer c.metaMux.RUnlock()
+	value, ok := c.meta[urlKey(url)][key]
+	return value, ok
+}
+
+// Meta returns a copy of all the metadata attached to url, or nil if
+// there is none.
+func (c *Crawler) Meta(url string) map[string]any {
+	c.metaMux.RLock()
+	defer c.metaMux.RUnlock()
+	return maps.Clone(c.meta[urlKey(url)])
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// MetricsFetcher wraps a Fetcher, recording every fetch that goes
+// through it in a StatsCollector as a request: its status, the size of
+// its body and its latency, in the fields InstrumentTransport fills in
+// for HTTP requests. Where it sits in a chain decides what it measures:
+// outside a CacheFetcher it sees every fetch of the crawl, inside one
+// only those that missed the cache.
+type MetricsFetcher struct {
+	stats   *StatsCollector
+	fetcher Fetcher
+}
+
+// NewMetricsFetcher returns a MetricsFetcher recording the fetches of
+// fetcher in stats.
+func NewMetricsFetcher(fetcher Fetcher, stats *StatsCollector) *MetricsFetcher {
+	return &MetricsFetcher{stats: stats, fetcher: fetcher}
+}
+
+func (f *MetricsFetcher) Fetch(url string) (string, []string, error) {
+	page, err := f.FetchContext(context.Background(), url)
+	if err != nil {
+		return "", nil, err
+	}
+	return page.Body, page.Links, nil
+}
+
+func (f *MetricsFetcher) FetchPage(url string) (*Page, error) {
+	return f.FetchContext(context.Background(), url)
+}
+
+// FetchContext fetches url with the wrapped fetcher and records the
+// outcome. A failed fetch is recorded with the status of its
+// StatusError, or zero if it has none.
+func (f *MetricsFetcher) FetchContext(ctx context.Context, url string) (*Page, error) {
+	start := time.Now()
+	page, err := fetchContext(ctx, f.fetcher, url)
+	if err != nil {
+		status := 0
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) {
+			status = statusErr.StatusCode
+		}
+		f.stats.requestDone(status, 0, time.Since(start))
+		return nil, err
+	}
+	f.stats.requestDone(page.StatusCode, int64(len(page.Body)), time.Since(start))
+	return page, nil
+}
+
+// Close closes the wrapped fetcher.
+func (f *MetricsFetcher) Close() error {
+	return closeFetcher(f.fetcher)
+}
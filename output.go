@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+)
+
+// ResultWriter writes CrawlResults in some file format. Close finishes
+// the output but does not close the underlying writer.
+type ResultWriter interface {
+	Write(result CrawlResult) error
+	Close() error
+}
+
+// MarshalJSON encodes r with lower-case keys, its error as a string and
+// empty fields omitted. A fetch RetryFetcher gave up on also gets the
+// number of attempts made.
+func (r CrawlResult) MarshalJSON() ([]byte, error) {
+	type record struct {
+		URL       string            `json:"url"`
+		Status    int               `json:"status,omitempty"`
+		Depth     int               `json:"depth"`
+		Path      []string          `json:"path,omitempty"`
+		Duration  float64           `json:"duration_ms,omitempty"`
+		Headers   map[string]string `json:"headers,omitempty"`
+		Links     []string          `json:"links,omitempty"`
+		Body      string            `json:"body,omitempty"`
+		Truncated bool              `json:"truncated,omitempty"`
+		Canonical string            `json:"canonical,omitempty"`
+		NoIndex   bool              `json:"noindex,omitempty"`
+		Duplicate bool              `json:"duplicate,omitempty"`
+		Unchanged bool              `json:"unchanged,omitempty"`
+		Revisit   bool              `json:"revisit,omitempty"`
+		Error     string            `json:"error,omitempty"`
+		Attempts  int               `json:"attempts,omitempty"`
+	}
+	rec := record{
+		URL:       r.URL,
+		Status:    r.StatusCode,
+		Depth:     r.Depth,
+		Path:      r.Path,
+		Duration:  durationMillis(r.Duration),
+		Headers:   r.Headers,
+		Links:     r.Links,
+		Body:      r.Body,
+		Truncated: r.Truncated,
+		Canonical: r.Canonical,
+		NoIndex:   r.NoIndex,
+		Duplicate: r.Duplicate,
+		Unchanged: r.Unchanged,
+		Revisit:   r.Revisit,
+	}
+	if r.Err != nil {
+		rec.Error = r.Err.Error()
+		var exhausted *RetriesExhaustedError
+		if errors.As(r.Err, &exhausted) {
+			rec.Attempts = exhausted.Attempts
+		}
+	}
+	return json.Marshal(rec)
+}
+
+// durationMillis returns d in milliseconds, to the microsecond.
+func durationMillis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// JSONWriter streams CrawlResults to an io.Writer as the elements of a
+// JSON array, writing each result as soon as it is passed to Write.
+// Close terminates the array, so the output is valid JSON as long as
+// Close is called, however early the crawl stopped.
+type JSONWriter struct {
+	w     io.Writer
+	count int
+}
+
+func NewJSONWriter(w io.Writer) *JSONWriter {
+	return &JSONWriter{w: w}
+}
+
+func (j *JSONWriter) Write(result CrawlResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if j.count == 0 {
+		sep = "[\n"
+	}
+	if _, err := io.WriteString(j.w, sep); err != nil {
+		return err
+	}
+	j.count++
+	_, err = j.w.Write(data)
+	return err
+}
+
+// Close ends the JSON array. It does not close the underlying writer.
+func (j *JSONWriter) Close() error {
+	end := "\n]\n"
+	if j.count == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(j.w, end)
+	return err
+}
+
+// NDJSONWriter streams CrawlResults to an io.Writer as newline-delimited
+// JSON, one object per line, for piping into another process as the
+// crawl runs. Each line goes out in a single Write as soon as it is
+// passed to Write, and is flushed if the writer has a Flush method such
+// as bufio.Writer's, so the output read so far is whole lines however
+// abruptly the crawl is stopped.
+type NDJSONWriter struct {
+	w io.Writer
+}
+
+func NewNDJSONWriter(w io.Writer) *NDJSONWriter {
+	return &NDJSONWriter{w: w}
+}
+
+func (n *NDJSONWriter) Write(result CrawlResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	if _, err := n.w.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if f, ok := n.w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Close does nothing: every line is complete once written.
+func (n *NDJSONWriter) Close() error {
+	return nil
+}
+
+// csvHeader names the columns written by CSVWriter.
+var csvHeader = []string{"url", "status", "depth", "links", "body_length", "duration_ms", "error"}
+
+// CSVWriter streams CrawlResults to an io.Writer as CSV, one row per
+// result under a header row, flushing each row as it is written.
+type CSVWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func NewCSVWriter(w io.Writer) *CSVWriter {
+	return &CSVWriter{w: csv.NewWriter(w)}
+}
+
+func (c *CSVWriter) Write(result CrawlResult) error {
+	c.writeHeader()
+	status, errText := "", ""
+	if result.StatusCode != 0 {
+		status = strconv.Itoa(result.StatusCode)
+	}
+	if result.Err != nil {
+		errText = result.Err.Error()
+	}
+	c.w.Write([]string{
+		result.URL,
+		status,
+		strconv.Itoa(result.Depth),
+		strconv.Itoa(len(result.Links)),
+		strconv.Itoa(len(result.Body)),
+		strconv.FormatFloat(durationMillis(result.Duration), 'f', -1, 64),
+		errText,
+	})
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// Close writes the header if no result was written, so the output is
+// never empty.
+func (c *CSVWriter) Close() error {
+	c.writeHeader()
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *CSVWriter) writeHeader() {
+	if !c.header {
+		c.header = true
+		c.w.Write(csvHeader)
+	}
+}
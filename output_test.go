@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCSVWriterRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []CrawlResult
+		want    [][]string
+	}{
+		{"empty", nil, [][]string{csvHeader}},
+		{
+			"plain",
+			[]CrawlResult{{URL: "https://example.com/", StatusCode: 200, Links: []string{"a", "b"}, Body: "hello", Duration: 1500 * time.Microsecond}},
+			[][]string{csvHeader, {"https://example.com/", "200", "0", "2", "5", "1.5", ""}},
+		},
+		{
+			"quoting",
+			[]CrawlResult{
+				{URL: "https://example.com/a,b", Depth: 1, Err: errors.New(`fetch "a,b": failed` + "\non two lines")},
+				{URL: `https://example.com/"q"`, StatusCode: 404, Depth: 2, Err: &StatusError{URL: `https://example.com/"q"`, StatusCode: 404}},
+			},
+			[][]string{
+				csvHeader,
+				{"https://example.com/a,b", "", "1", "0", "0", "0", `fetch "a,b": failed` + "\non two lines"},
+				{`https://example.com/"q"`, "404", "2", "0", "0", "0", `fetch https://example.com/"q": 404 Not Found`},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			w := NewCSVWriter(&b)
+			for _, result := range tt.results {
+				if err := w.Write(result); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			got, err := csv.NewReader(strings.NewReader(b.String())).ReadAll()
+			if err != nil {
+				t.Fatalf("reading back %q: %v", b.String(), err)
+			}
+			if !slices.EqualFunc(got, tt.want, slices.Equal) {
+				t.Errorf("read back %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNDJSONWriterRoundTrip(t *testing.T) {
+	results := []CrawlResult{
+		{URL: "https://example.com/", StatusCode: 200, Links: []string{"https://example.com/a"}, Body: "line one\nline two", Duration: 2 * time.Millisecond},
+		{URL: "https://example.com/a", Depth: 1, Path: []string{"https://example.com/"}, Err: &RetriesExhaustedError{URL: "https://example.com/a", Attempts: 3, Err: errors.New("timeout")}},
+	}
+	type record struct {
+		URL      string   `json:"url"`
+		Status   int      `json:"status"`
+		Depth    int      `json:"depth"`
+		Path     []string `json:"path"`
+		Duration float64  `json:"duration_ms"`
+		Links    []string `json:"links"`
+		Body     string   `json:"body"`
+		Error    string   `json:"error"`
+		Attempts int      `json:"attempts"`
+	}
+	want := []record{
+		{URL: "https://example.com/", Status: 200, Duration: 2, Links: []string{"https://example.com/a"}, Body: "line one\nline two"},
+		{URL: "https://example.com/a", Depth: 1, Path: []string{"https://example.com/"}, Error: "gave up after 3 attempts: timeout", Attempts: 3},
+	}
+
+	// The bufio.Writer holds more than the whole output, so only the
+	// flushes of NDJSONWriter get the lines to out.
+	var out strings.Builder
+	w := NewNDJSONWriter(bufio.NewWriterSize(&out, 1<<16))
+	for i, result := range results {
+		if err := w.Write(result); err != nil {
+			t.Fatal(err)
+		}
+		if lines := strings.Count(out.String(), "\n"); lines != i+1 || !strings.HasSuffix(out.String(), "\n") {
+			t.Fatalf("after %d writes the output is %q, want %d whole lines", i+1, out.String(), i+1)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d: %q", len(lines), len(want), out.String())
+	}
+	for i, line := range lines {
+		var got record
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatalf("line %d: %v", i+1, err)
+		}
+		if !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("line %d read back as %+v, want %+v", i+1, got, want[i])
+		}
+	}
+}
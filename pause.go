@@ -0,0 +1,55 @@
+package main
+
+import "context"
+
+// Pause stops the Crawler from starting fetches until Unpause is
+// called. Fetches in progress run to completion and their results are
+// reported, but their links wait with the rest of the frontier; the
+// visited set is kept as it is. Pausing does not stop the clock of
+// Config.MaxDuration, and cancelling the context still ends a paused
+// crawl. Pausing a paused Crawler does nothing.
+//
+// Crawler.Resume, which continues a crawl from a saved frontier, is
+// unrelated: it starts a crawl, where Unpause lets one go on.
+func (c *Crawler) Pause() {
+	c.pauseMux.Lock()
+	defer c.pauseMux.Unlock()
+	if c.unpaused == nil {
+		c.unpaused = make(chan struct{})
+	}
+}
+
+// Unpause lets a paused Crawler start fetches again. Unpausing a
+// Crawler that is not paused does nothing.
+func (c *Crawler) Unpause() {
+	c.pauseMux.Lock()
+	defer c.pauseMux.Unlock()
+	if c.unpaused != nil {
+		close(c.unpaused)
+		c.unpaused = nil
+	}
+}
+
+// Paused reports whether the Crawler is paused.
+func (c *Crawler) Paused() bool {
+	c.pauseMux.Lock()
+	defer c.pauseMux.Unlock()
+	return c.unpaused != nil
+}
+
+// waitUnpaused blocks while the Crawler is paused, returning ctx's
+// error if it is cancelled first.
+func (c *Crawler) waitUnpaused(ctx context.Context) error {
+	c.pauseMux.Lock()
+	unpaused := c.unpaused
+	c.pauseMux.Unlock()
+	if unpaused == nil {
+		return nil
+	}
+	select {
+	case <-unpaused:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
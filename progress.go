@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// StartProgress writes a line to w every interval with the number of
+// pages crawled so far, the number of fetches in flight and the crawl
+// rate over the last interval. The returned function stops the reporter
+// and waits for it to exit. A non-positive interval disables reporting.
+func StartProgress(w io.Writer, stats *StatsCollector, interval time.Duration) (stop func()) {
+	if interval <= 0 || stats == nil {
+		return func() {}
+	}
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		last, lastTime := int64(0), time.Now()
+		for {
+			select {
+			case <-quit:
+				return
+			case now := <-ticker.C:
+				s := stats.Stats()
+				rate := float64(s.PagesFetched-last) / now.Sub(lastTime).Seconds()
+				fmt.Fprintf(w, "progress: %d pages, %d in flight, %.1f pages/s\n", s.PagesFetched, s.InFlight, rate)
+				last, lastTime = s.PagesFetched, now
+			}
+		}
+	}()
+	return func() {
+		close(quit)
+		<-done
+	}
+}
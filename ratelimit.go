@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitFetcher wraps a Fetcher so that requests to the same host
+// are issued at most once per delay. Requests to different hosts don't
+// wait on each other. A host can be given a delay of its own with
+// SetHostDelay, as its robots.txt Crawl-delay asks; the larger of that
+// delay and the default one applies, so whichever is more polite wins.
+// JitterRange is added on top of either.
+type RateLimitFetcher struct {
+	// JitterRange, if positive, lengthens the gap before each request to
+	// a host by a random amount in [0, JitterRange), so that requests
+	// don't arrive in lockstep. Setting it to the delay spreads the gaps
+	// over [delay, 2*delay). It must be set before the first fetch.
+	JitterRange time.Duration
+
+	delay      time.Duration
+	mux        sync.Mutex
+	next       map[string]time.Time
+	hostDelays map[string]time.Duration
+	fetcher    Fetcher
+}
+
+func (f *RateLimitFetcher) Fetch(rawURL string) (string, []string, error) {
+	page, err := f.FetchContext(context.Background(), rawURL)
+	if err != nil {
+		return "", nil, err
+	}
+	return page.Body, page.Links, nil
+}
+
+func (f *RateLimitFetcher) FetchPage(rawURL string) (*Page, error) {
+	return f.FetchContext(context.Background(), rawURL)
+}
+
+// FetchContext waits for rawURL's host to come up and fetches it. It
+// gives up waiting when ctx is done, though the slot stays booked.
+func (f *RateLimitFetcher) FetchContext(ctx context.Context, rawURL string) (*Page, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if wait := f.reserve(strings.ToLower(u.Host)); wait > 0 {
+		if err := sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+	return fetchContext(ctx, f.fetcher, rawURL)
+}
+
+// sleep waits for d, returning ctx.Err() early if ctx is done first.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close closes the wrapped fetcher.
+func (f *RateLimitFetcher) Close() error {
+	return closeFetcher(f.fetcher)
+}
+
+// SetHostDelay sets the delay between requests to host, such as
+// "example.com" or "example.com:8080", where it is larger than the
+// default delay. It takes effect from the next request booked.
+func (f *RateLimitFetcher) SetHostDelay(host string, delay time.Duration) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.hostDelays[strings.ToLower(host)] = delay
+}
+
+// reserve books the next request slot for host and returns how long
+// the caller has to wait until that slot comes up.
+func (f *RateLimitFetcher) reserve(host string) time.Duration {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	now := time.Now()
+	slot := f.next[host]
+	if slot.Before(now) {
+		slot = now
+	}
+	gap := max(f.delay, f.hostDelays[host])
+	if f.JitterRange > 0 {
+		// The top-level functions of math/rand/v2 are randomly
+		// seeded and safe for concurrent use.
+		gap += rand.N(f.JitterRange)
+	}
+	f.next[host] = slot.Add(gap)
+	return slot.Sub(now)
+}
+
+// NewRateLimitFetcher returns a RateLimitFetcher in front of fetcher,
+// spacing the requests to each host delay apart.
+func NewRateLimitFetcher(fetcher Fetcher, delay time.Duration) *RateLimitFetcher {
+	return &RateLimitFetcher{
+		delay:      delay,
+		next:       make(map[string]time.Time),
+		hostDelays: make(map[string]time.Duration),
+		fetcher:    fetcher,
+	}
+}
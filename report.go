@@ -0,0 +1,74 @@
+package main
+
+import (
+	"cmp"
+	"encoding/csv"
+	"errors"
+	"io"
+	"slices"
+	"strconv"
+)
+
+// BrokenLink is a URL that could not be fetched, with the pages linking
+// to it.
+type BrokenLink struct {
+	URL string
+	// StatusCode is the HTTP status of the response, or zero if the fetch
+	// failed without one.
+	StatusCode int
+	Err        error
+	// Parents are the fetched pages linking to URL, sorted.
+	Parents []string
+}
+
+// BrokenLinks matches the failed fetches in errs, keyed by URL as
+// collected by an ErrorCollector, with the pages that link to them in
+// graph, as recorded by a LinkGraph. The result is sorted by URL.
+func BrokenLinks(errs map[string]error, graph map[string][]string) []BrokenLink {
+	parents := make(map[string][]string)
+	for parent, children := range graph {
+		for _, child := range children {
+			key := urlKey(child)
+			if !slices.Contains(parents[key], parent) {
+				parents[key] = append(parents[key], parent)
+			}
+		}
+	}
+	links := make([]BrokenLink, 0, len(errs))
+	for url, err := range errs {
+		link := BrokenLink{URL: url, Err: err, Parents: parents[urlKey(url)]}
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) {
+			link.StatusCode = statusErr.StatusCode
+		}
+		slices.Sort(link.Parents)
+		links = append(links, link)
+	}
+	slices.SortFunc(links, func(a, b BrokenLink) int {
+		return cmp.Compare(a.URL, b.URL)
+	})
+	return links
+}
+
+// WriteBrokenLinksCSV writes links to w as CSV with a header row and one
+// row for every page linking to a broken URL. A broken URL without known
+// parents, such as the seed, gets a single row with an empty parent.
+func WriteBrokenLinksCSV(w io.Writer, links []BrokenLink) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"url", "status", "error", "linked_from"})
+	for _, link := range links {
+		status := ""
+		if link.StatusCode != 0 {
+			status = strconv.Itoa(link.StatusCode)
+		}
+		parents := link.Parents
+		if len(parents) == 0 {
+			parents = []string{""}
+		}
+		for _, parent := range parents {
+			cw.Write([]string{link.URL, status, link.Err.Error(), parent})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
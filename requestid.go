@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+)
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying id, the number a Crawler
+// gives each fetch so that the log lines of one fetch, from the crawler
+// as from the fetchers it goes through, can be told apart from those of
+// the fetches running alongside it.
+func WithRequestID(ctx context.Context, id uint64) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, if any.
+func RequestID(ctx context.Context) (uint64, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(uint64)
+	return id, ok
+}
+
+// fetchLogger returns l, or a logger discarding everything if l is nil,
+// adding the request ID of ctx, if any, to every message as "req".
+func fetchLogger(ctx context.Context, l *slog.Logger) *slog.Logger {
+	l = orDiscard(l)
+	if id, ok := RequestID(ctx); ok && l != discardLogger {
+		return l.With("req", id)
+	}
+	return l
+}
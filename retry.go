@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// RetryFetcher wraps a Fetcher and retries fetches that fail with a
+// transient error, waiting an exponentially growing, jittered delay
+// between attempts. Permanent failures such as a 404 are returned
+// immediately.
+type RetryFetcher struct {
+	// Budget, if set, caps the retries of all the fetchers sharing it.
+	// Once it is spent, failures are returned without retrying.
+	Budget *RetryBudget
+	// Logger, if set, receives every retry, with the request ID of the
+	// fetch if it has one.
+	Logger *slog.Logger
+
+	maxAttempts int
+	baseDelay   time.Duration
+	fetcher     Fetcher
+}
+
+// ErrRetriesExhausted is matched by the RetriesExhaustedError of a fetch
+// that kept failing until RetryFetcher gave up on it.
+var ErrRetriesExhausted = errors.New("retries exhausted")
+
+// RetriesExhaustedError is returned by RetryFetcher for a fetch that
+// was retried and still failed with a transient error when it ran out of
+// attempts or budget. It wraps the error of the last attempt, so a
+// StatusError, say, can still be found with errors.As.
+type RetriesExhaustedError struct {
+	URL string
+	// Attempts is the number of times the URL was fetched.
+	Attempts int
+	Err      error
+}
+
+func (e *RetriesExhaustedError) Error() string {
+	return fmt.Sprintf("gave up after %d attempts: %v", e.Attempts, e.Err)
+}
+
+func (e *RetriesExhaustedError) Unwrap() error { return e.Err }
+
+// Is reports whether target is ErrRetriesExhausted.
+func (e *RetriesExhaustedError) Is(target error) bool {
+	return target == ErrRetriesExhausted
+}
+
+// RetryBudget is a number of retries shared by a crawl, so that a site
+// going down mid-run costs a bounded number of retries rather than a
+// few for every URL left. It is safe for concurrent use; a nil
+// RetryBudget is unlimited.
+type RetryBudget struct {
+	remaining atomic.Int64
+}
+
+// NewRetryBudget returns a RetryBudget allowing n retries in all.
+func NewRetryBudget(n int) *RetryBudget {
+	b := &RetryBudget{}
+	b.remaining.Store(int64(n))
+	return b
+}
+
+// take spends one retry, reporting false if none are left.
+func (b *RetryBudget) take() bool {
+	if b == nil {
+		return true
+	}
+	if b.remaining.Add(-1) < 0 {
+		b.remaining.Add(1)
+		return false
+	}
+	return true
+}
+
+// Remaining returns the number of retries left, or -1 if b is nil.
+func (b *RetryBudget) Remaining() int {
+	if b == nil {
+		return -1
+	}
+	return int(b.remaining.Load())
+}
+
+func (f *RetryFetcher) Fetch(url string) (string, []string, error) {
+	page, err := f.FetchContext(context.Background(), url)
+	if err != nil {
+		return "", nil, err
+	}
+	return page.Body, page.Links, nil
+}
+
+func (f *RetryFetcher) FetchPage(url string) (*Page, error) {
+	return f.FetchContext(context.Background(), url)
+}
+
+// FetchContext fetches url, retrying transient failures until an attempt
+// succeeds, the attempts or the budget run out or ctx is done. A fetch
+// given up on after more than one attempt fails with a
+// RetriesExhaustedError.
+func (f *RetryFetcher) FetchContext(ctx context.Context, url string) (*Page, error) {
+	for attempt := 1; ; attempt++ {
+		page, err := fetchContext(ctx, f.fetcher, url)
+		if err == nil || !isRetryable(err) || ctx.Err() != nil {
+			return page, err
+		}
+		if attempt >= f.maxAttempts || !f.Budget.take() {
+			if attempt > 1 {
+				err = &RetriesExhaustedError{URL: url, Attempts: attempt, Err: err}
+			}
+			return nil, err
+		}
+		wait := f.backoff(attempt)
+		fetchLogger(ctx, f.Logger).Info("retrying", "url", url, "attempt", attempt+1, "wait", wait, "err", err)
+		if err := sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Close closes the wrapped fetcher.
+func (f *RetryFetcher) Close() error {
+	return closeFetcher(f.fetcher)
+}
+
+// maxBackoff caps the delay between retries, however many attempts
+// came before.
+const maxBackoff = 5 * time.Minute
+
+// backoff returns the delay before retrying after the given attempt:
+// baseDelay doubled for every earlier attempt, up to maxBackoff, with the
+// upper half randomized so that concurrent retries spread out.
+func (f *RetryFetcher) backoff(attempt int) time.Duration {
+	if f.baseDelay <= 0 {
+		return 0
+	}
+	d := f.baseDelay
+	// Doubling stops at the cap rather than shifting into an overflow.
+	for i := 1; i < attempt && d < maxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, maxBackoff)
+	half := d / 2
+	return half + rand.N(d-half)
+}
+
+// isRetryable reports whether err looks transient: a timeout, or a
+// 5xx or 429 response.
+func isRetryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// NewRetryFetcher returns a RetryFetcher making at most maxAttempts
+// attempts per URL, the first retry waiting around baseDelay.
+func NewRetryFetcher(fetcher Fetcher, maxAttempts int, baseDelay time.Duration) *RetryFetcher {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &RetryFetcher{
+		maxAttempts: maxAttempts,
+		baseDelay:   baseDelay,
+		fetcher:     fetcher,
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// flakyFetcher fails a fetch with each of its statuses in turn, then
+// serves the page.
+type flakyFetcher struct {
+	statuses []int
+	calls    int
+}
+
+func (f *flakyFetcher) Fetch(url string) (string, []string, error) {
+	f.calls++
+	if f.calls <= len(f.statuses) {
+		return "", nil, &StatusError{URL: url, StatusCode: f.statuses[f.calls-1]}
+	}
+	return "ok", nil, nil
+}
+
+func TestRetryFetcherStatuses(t *testing.T) {
+	tests := []struct {
+		name      string
+		statuses  []int
+		wantCalls int
+		// wantStatus is that of the StatusError expected, or zero for
+		// success.
+		wantStatus    int
+		wantExhausted bool
+	}{
+		{"success", nil, 1, 0, false},
+		{"5xx retried", []int{503}, 2, 0, false},
+		{"429 retried", []int{429, 500}, 3, 0, false},
+		{"5xx until out of attempts", []int{500, 502, 503, 504}, 3, 503, true},
+		{"404 not retried", []int{404}, 1, 404, false},
+		{"403 not retried", []int{403}, 1, 403, false},
+		{"4xx after a 5xx", []int{502, 410}, 2, 410, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flaky := &flakyFetcher{statuses: tt.statuses}
+			_, _, err := NewRetryFetcher(flaky, 3, time.Microsecond).Fetch("https://example.com/")
+			if flaky.calls != tt.wantCalls {
+				t.Errorf("fetched %d times, want %d", flaky.calls, tt.wantCalls)
+			}
+			var statusErr *StatusError
+			switch {
+			case tt.wantStatus == 0 && err != nil:
+				t.Errorf("Fetch: %v", err)
+			case tt.wantStatus != 0 && (!errors.As(err, &statusErr) || statusErr.StatusCode != tt.wantStatus):
+				t.Errorf("Fetch error = %v, want status %d", err, tt.wantStatus)
+			}
+			if got := errors.Is(err, ErrRetriesExhausted); got != tt.wantExhausted {
+				t.Errorf("errors.Is(%v, ErrRetriesExhausted) = %v, want %v", err, got, tt.wantExhausted)
+			}
+		})
+	}
+}
+
+func TestRetryFetcherBackoffHonorsContext(t *testing.T) {
+	flaky := &flakyFetcher{statuses: []int{503, 503}}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := NewRetryFetcher(flaky, 3, time.Hour).FetchContext(ctx, "https://example.com/")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("FetchContext error = %v, want context.DeadlineExceeded", err)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("FetchContext returned after %v, want it to stop waiting when ctx is done", took)
+	}
+	if flaky.calls != 1 {
+		t.Errorf("fetched %d times, want 1", flaky.calls)
+	}
+}
+
+func TestRetryFetcherBackoffBounds(t *testing.T) {
+	tests := []struct {
+		base     time.Duration
+		attempt  int
+		min, max time.Duration
+	}{
+		{time.Second, 1, 500 * time.Millisecond, time.Second},
+		{time.Second, 3, 2 * time.Second, 4 * time.Second},
+		{time.Second, 20, maxBackoff / 2, maxBackoff},
+		{time.Second, 64, maxBackoff / 2, maxBackoff},
+		{time.Second, 1000, maxBackoff / 2, maxBackoff},
+		{time.Hour, 1, maxBackoff / 2, maxBackoff},
+		{0, 10, 0, 0},
+	}
+	for _, tt := range tests {
+		f := NewRetryFetcher(nil, 3, tt.base)
+		for range 10 {
+			if got := f.backoff(tt.attempt); got < tt.min || got > tt.max {
+				t.Errorf("backoff(%d) with base %v = %v, want between %v and %v", tt.attempt, tt.base, got, tt.min, tt.max)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrDisallowed is returned by RobotsFetcher for URLs that the
+// site's robots.txt does not allow us to fetch.
+var ErrDisallowed = errors.New("disallowed by robots.txt")
+
+// robotsRule is a single Allow or Disallow line.
+type robotsRule struct {
+	allow   bool
+	length  int
+	pattern *regexp.Regexp
+}
+
+// robotsRules holds the rules of robots.txt that apply to one user agent.
+// A nil *robotsRules allows everything.
+type robotsRules struct {
+	rules []robotsRule
+	// crawlDelay is the Crawl-delay of the agent's group, or zero.
+	crawlDelay time.Duration
+}
+
+// parseRobots parses a robots.txt body and returns the rules and the
+// Crawl-delay for userAgent. Groups naming the agent's product token take
+// precedence over the "*" group; lines that can't be parsed are ignored.
+func parseRobots(body, userAgent string) *robotsRules {
+	type group struct {
+		agents []string
+		rules  []robotsRule
+		delay  time.Duration
+	}
+	var groups []*group
+	var current *group
+	inRules := false
+	for _, line := range strings.Split(body, "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		switch key {
+		case "user-agent":
+			if current == nil || inRules {
+				current = &group{}
+				groups = append(groups, current)
+				inRules = false
+			}
+			current.agents = append(current.agents, strings.ToLower(value))
+		case "allow", "disallow":
+			if current == nil {
+				continue
+			}
+			inRules = true
+			if value == "" {
+				// An empty Disallow matches nothing.
+				continue
+			}
+			current.rules = append(current.rules, robotsRule{
+				allow:   key == "allow",
+				length:  len(value),
+				pattern: compileRobotsPattern(value),
+			})
+		case "crawl-delay":
+			if current == nil {
+				continue
+			}
+			inRules = true
+			// The delay is in seconds, possibly fractional.
+			if secs, err := strconv.ParseFloat(value, 64); err == nil && secs > 0 {
+				current.delay = time.Duration(secs * float64(time.Second))
+			}
+		}
+	}
+
+	token := strings.ToLower(userAgent)
+	if i := strings.IndexAny(token, "/ "); i >= 0 {
+		token = token[:i]
+	}
+	var named, wildcard robotsRules
+	var isNamed bool
+	for _, g := range groups {
+		for _, agent := range g.agents {
+			if agent == "*" {
+				wildcard.rules = append(wildcard.rules, g.rules...)
+				wildcard.crawlDelay = max(wildcard.crawlDelay, g.delay)
+				break
+			}
+			if token != "" && agent == token {
+				// A group naming the agent applies even if its
+				// rules are empty, allowing everything.
+				isNamed = true
+				named.rules = append(named.rules, g.rules...)
+				named.crawlDelay = max(named.crawlDelay, g.delay)
+				break
+			}
+		}
+	}
+	if isNamed {
+		return &named
+	}
+	return &wildcard
+}
+
+// compileRobotsPattern turns a robots.txt path pattern into a regexp
+// anchored at the start of the path. "*" matches any sequence of
+// characters and a trailing "$" anchors the end of the path.
+func compileRobotsPattern(pattern string) *regexp.Regexp {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	parts := strings.Split(pattern, "*")
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+	expr := "^" + strings.Join(parts, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
+
+// Allowed reports whether path may be fetched. The longest matching
+// rule wins, and Allow wins a tie with Disallow.
+func (r *robotsRules) Allowed(path string) bool {
+	if r == nil {
+		return true
+	}
+	allowed, longest := true, -1
+	for _, rule := range r.rules {
+		if !rule.pattern.MatchString(path) {
+			continue
+		}
+		if rule.length > longest || (rule.length == longest && rule.allow) {
+			allowed, longest = rule.allow, rule.length
+		}
+	}
+	return allowed
+}
+
+type robotsEntry struct {
+	// mux is held while robots.txt is fetched, so that other callers
+	// for the host wait for the rules.
+	mux     sync.Mutex
+	fetched bool
+	rules   *robotsRules
+}
+
+// RobotsFetcher wraps a Fetcher and refuses, with ErrDisallowed, to
+// fetch URLs that robots.txt disallows for its user agent. Each host's
+// robots.txt is fetched once through the wrapped fetcher and cached; a
+// missing robots.txt allows everything.
+type RobotsFetcher struct {
+	// OnCrawlDelay, if set, is called with the host and the delay of
+	// every robots.txt giving a Crawl-delay for the user agent, before
+	// the first page of the host is fetched. Setting it to the
+	// SetHostDelay method of a RateLimitFetcher wrapped by this one
+	// makes the crawl honor the delays. It must be set before the
+	// first fetch.
+	OnCrawlDelay func(host string, delay time.Duration)
+
+	userAgent string
+	mux       sync.Mutex
+	hosts     map[string]*robotsEntry
+	fetcher   Fetcher
+}
+
+func (f *RobotsFetcher) Fetch(rawURL string) (string, []string, error) {
+	page, err := f.FetchContext(context.Background(), rawURL)
+	if err != nil {
+		return "", nil, err
+	}
+	return page.Body, page.Links, nil
+}
+
+func (f *RobotsFetcher) FetchPage(rawURL string) (*Page, error) {
+	return f.FetchContext(context.Background(), rawURL)
+}
+
+func (f *RobotsFetcher) FetchContext(ctx context.Context, rawURL string) (*Page, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	if !f.rules(ctx, u).Allowed(path) {
+		return nil, &url.Error{Op: "Fetch", URL: rawURL, Err: ErrDisallowed}
+	}
+	return fetchContext(ctx, f.fetcher, rawURL)
+}
+
+// Close closes the wrapped fetcher.
+func (f *RobotsFetcher) Close() error {
+	return closeFetcher(f.fetcher)
+}
+
+// rules returns the cached rules for u's host, fetching robots.txt with
+// ctx on first use. Concurrent callers for the same host share one
+// fetch. A fetch cut short by ctx is not remembered, so the next caller
+// tries again.
+func (f *RobotsFetcher) rules(ctx context.Context, u *url.URL) *robotsRules {
+	origin := u.Scheme + "://" + strings.ToLower(u.Host)
+	f.mux.Lock()
+	entry, ok := f.hosts[origin]
+	if !ok {
+		entry = &robotsEntry{}
+		f.hosts[origin] = entry
+	}
+	f.mux.Unlock()
+
+	entry.mux.Lock()
+	defer entry.mux.Unlock()
+	if entry.fetched {
+		return entry.rules
+	}
+	page, err := fetchContext(ctx, f.fetcher, origin+"/robots.txt")
+	if err != nil && ctx.Err() != nil {
+		return nil
+	}
+	entry.fetched = true
+	if err == nil {
+		entry.rules = parseRobots(page.Body, f.userAgent)
+	}
+	if entry.rules != nil && entry.rules.crawlDelay > 0 && f.OnCrawlDelay != nil {
+		f.OnCrawlDelay(strings.ToLower(u.Host), entry.rules.crawlDelay)
+	}
+	return entry.rules
+}
+
+// NewRobotsFetcher returns a RobotsFetcher in front of fetcher, obeying
+// the robots.txt rules for userAgent.
+func NewRobotsFetcher(fetcher Fetcher, userAgent string) *RobotsFetcher {
+	return &RobotsFetcher{
+		userAgent: userAgent,
+		hosts:     make(map[string]*robotsEntry),
+		fetcher:   fetcher,
+	}
+}
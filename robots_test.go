@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRobotsGroups(t *testing.T) {
+	tests := []struct {
+		name, body, agent string
+		path              string
+		want              bool
+	}{
+		{"no groups", "", "crawler/1.0", "/private", true},
+		{"wildcard group", "User-agent: *\nDisallow: /private", "crawler/1.0", "/private/x", false},
+		{"wildcard group elsewhere", "User-agent: *\nDisallow: /private", "crawler/1.0", "/public", true},
+		{"named group wins", "User-agent: crawler\nDisallow: /a\n\nUser-agent: *\nDisallow: /", "crawler/1.0", "/b", true},
+		{"named group rules", "User-agent: crawler\nDisallow: /a\n\nUser-agent: *\nDisallow: /", "crawler/1.0", "/a", false},
+		{"named group allowing all", "User-agent: crawler\nDisallow:\n\nUser-agent: *\nDisallow: /", "crawler/1.0", "/anything", true},
+		{"named with crawl-delay only", "User-agent: crawler\nCrawl-delay: 2\n\nUser-agent: *\nDisallow: /", "crawler/1.0", "/anything", true},
+		{"other agent's group ignored", "User-agent: otherbot\nDisallow: /\n\nUser-agent: *\nDisallow: /x", "crawler/1.0", "/y", true},
+		{"agent matched case-insensitively", "User-agent: Crawler\nDisallow: /a", "crawler/1.0", "/a", false},
+		{"group of several agents", "User-agent: otherbot\nUser-agent: crawler\nDisallow: /a", "crawler/1.0", "/a", false},
+		{"comments ignored", "User-agent: * # everyone\nDisallow: /a # not a\n", "crawler/1.0", "/a", false},
+		{"rules before any agent ignored", "Disallow: /\nUser-agent: *\nDisallow: /a", "crawler/1.0", "/b", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRobots(tt.body, tt.agent).Allowed(tt.path); got != tt.want {
+				t.Errorf("Allowed(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRobotsAllowed(t *testing.T) {
+	const body = `User-agent: *
+Disallow: /private
+Allow: /private/public
+Disallow: /*.pdf$
+Disallow: /search*q=
+Allow: /tie
+Disallow: /tie
+Disallow: /exact$
+`
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/", true},
+		{"/private", false},
+		{"/private/secret", false},
+		{"/private/public/page", true},
+		{"/docs/report.pdf", false},
+		{"/docs/report.pdf?download=1", true},
+		{"/docs/report.pdfx", true},
+		{"/search?q=go", false},
+		{"/search/advanced?lang=en&q=go", false},
+		{"/search?lang=en", true},
+		{"/tie", true},
+		{"/exact", false},
+		{"/exact/more", true},
+	}
+	rules := parseRobots(body, "crawler/1.0")
+	for _, tt := range tests {
+		if got := rules.Allowed(tt.path); got != tt.want {
+			t.Errorf("Allowed(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestParseRobotsCrawlDelay(t *testing.T) {
+	body := "User-agent: crawler\nCrawl-delay: 1.5\n\nUser-agent: *\nCrawl-delay: 10"
+	if got := parseRobots(body, "crawler/1.0").crawlDelay; got != 1500*time.Millisecond {
+		t.Errorf("crawl delay for the named group = %s, want 1.5s", got)
+	}
+	if got := parseRobots(body, "otherbot").crawlDelay; got != 10*time.Second {
+		t.Errorf("crawl delay for the wildcard group = %s, want 10s", got)
+	}
+}
+
+// contextRecorder is a ContextFetcher serving fakeFetcher pages and
+// recording the request ID of each fetch of robots.txt.
+type contextRecorder struct {
+	pages     fakeFetcher
+	robotsIDs []uint64
+}
+
+func (f *contextRecorder) Fetch(url string) (string, []string, error) {
+	return f.pages.Fetch(url)
+}
+
+func (f *contextRecorder) FetchPage(url string) (*Page, error) {
+	return f.FetchContext(context.Background(), url)
+}
+
+func (f *contextRecorder) FetchContext(ctx context.Context, url string) (*Page, error) {
+	if strings.HasSuffix(url, "/robots.txt") {
+		id, _ := RequestID(ctx)
+		f.robotsIDs = append(f.robotsIDs, id)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	body, links, err := f.pages.Fetch(url)
+	if err != nil {
+		return nil, err
+	}
+	return &Page{URL: url, Body: body, Links: links}, nil
+}
+
+func TestRobotsFetcherContext(t *testing.T) {
+	recorder := &contextRecorder{pages: fakeFetcher{
+		"https://example.com/robots.txt": &fakeResult{body: "User-agent: *\nDisallow: /private"},
+		"https://example.com/private":    &fakeResult{body: "secret"},
+	}}
+	robots := NewRobotsFetcher(recorder, "crawler/1.0")
+
+	cancelled, cancel := context.WithCancel(WithRequestID(context.Background(), 1))
+	cancel()
+	if _, err := robots.FetchContext(cancelled, "https://example.com/private"); !errors.Is(err, context.Canceled) {
+		t.Errorf("FetchContext with a cancelled context = %v, want context.Canceled", err)
+	}
+	// The cancelled fetch of robots.txt is not taken for a missing one.
+	if _, err := robots.FetchContext(WithRequestID(context.Background(), 2), "https://example.com/private"); !errors.Is(err, ErrDisallowed) {
+		t.Errorf("FetchContext = %v, want ErrDisallowed", err)
+	}
+	robots.FetchContext(WithRequestID(context.Background(), 3), "https://example.com/private")
+	// robots.txt went out once, for the fetch whose context was live,
+	// with its request ID.
+	if want := []uint64{2}; !slices.Equal(recorder.robotsIDs, want) {
+		t.Errorf("robots.txt fetched with request IDs %v, want %v", recorder.robotsIDs, want)
+	}
+}
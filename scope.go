@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// HostScope limits a crawl to a set of hosts: those of its seed URLs,
+// or those matching an allowlist.
+type HostScope struct {
+	hosts []hostPattern
+}
+
+// hostPattern matches a host, or with wildcard its subdomains. A host
+// without a port matches on every port if anyPort is set.
+type hostPattern struct {
+	host     string
+	wildcard bool
+	anyPort  bool
+}
+
+func (p hostPattern) matches(u *url.URL) bool {
+	host := strings.ToLower(u.Host)
+	if p.anyPort {
+		host = strings.ToLower(u.Hostname())
+	}
+	if p.wildcard {
+		return strings.HasSuffix(host, "."+p.host)
+	}
+	return host == p.host
+}
+
+// NewHostScope returns a scope admitting URLs on the same host as seed.
+// If subdomains is true, URLs on subdomains of that host are admitted too.
+func NewHostScope(seed string, subdomains bool) (*HostScope, error) {
+	return NewSeedsScope([]string{seed}, subdomains)
+}
+
+// NewSeedsScope is NewHostScope for a crawl from several seeds,
+// admitting URLs on the host of any of them.
+func NewSeedsScope(seeds []string, subdomains bool) (*HostScope, error) {
+	s := &HostScope{}
+	for _, seed := range seeds {
+		u, err := url.Parse(urlKey(seed))
+		if err != nil {
+			return nil, err
+		}
+		host := hostPattern{host: strings.ToLower(u.Host)}
+		if slices.Contains(s.hosts, host) {
+			continue
+		}
+		s.hosts = append(s.hosts, host)
+		if subdomains {
+			s.hosts = append(s.hosts, hostPattern{host: host.host, wildcard: true})
+		}
+	}
+	return s, nil
+}
+
+// NewAllowlistScope returns a scope admitting URLs on the hosts in
+// allowed. An entry "*.example.com" admits the subdomains of
+// example.com, but not example.com itself, which needs an entry of its
+// own. An entry without a port admits its host on any port.
+func NewAllowlistScope(allowed []string) (*HostScope, error) {
+	s := &HostScope{}
+	for _, entry := range allowed {
+		host := strings.ToLower(strings.TrimSpace(entry))
+		wildcard := strings.HasPrefix(host, "*.")
+		host = strings.TrimPrefix(host, "*.")
+		if host == "" || strings.ContainsAny(host, "*/") {
+			return nil, fmt.Errorf("invalid allowed host %q", entry)
+		}
+		_, _, err := net.SplitHostPort(host)
+		s.hosts = append(s.hosts, hostPattern{host: host, wildcard: wildcard, anyPort: err != nil})
+	}
+	return s, nil
+}
+
+// Allows reports whether rawURL is within the scope. Hosts are compared
+// case-insensitively, ignoring default ports. A nil scope allows every URL.
+func (s *HostScope) Allows(rawURL string) bool {
+	if s == nil {
+		return true
+	}
+	u, err := url.Parse(urlKey(rawURL))
+	if err != nil {
+		return false
+	}
+	for _, host := range s.hosts {
+		if host.matches(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostList is a flag.Value collecting the entries of a host allowlist.
+// The flag may be repeated, and each value may list several hosts
+// separated by commas.
+type hostList []string
+
+func (h *hostList) String() string {
+	if h == nil {
+		return ""
+	}
+	return strings.Join(*h, ",")
+}
+
+func (h *hostList) Set(hosts string) error {
+	for _, host := range strings.Split(hosts, ",") {
+		if host = strings.TrimSpace(host); host == "" {
+			continue
+		}
+		if _, err := NewAllowlistScope([]string{host}); err != nil {
+			return err
+		}
+		*h = append(*h, host)
+	}
+	return nil
+}
+
+// PatternFilter selects URLs by regular expression. A URL passes if it
+// matches at least one Include pattern (or Include is empty) and no
+// Exclude pattern; Exclude takes precedence when a URL matches both.
+// A nil PatternFilter passes every URL.
+type PatternFilter struct {
+	Include []*regexp.Regexp
+	Exclude []*regexp.Regexp
+}
+
+// Allows reports whether rawURL passes the filter.
+func (f *PatternFilter) Allows(rawURL string) bool {
+	if f == nil {
+		return true
+	}
+	for _, re := range f.Exclude {
+		if re.MatchString(rawURL) {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, re := range f.Include {
+		if re.MatchString(rawURL) {
+			return true
+		}
+	}
+	return false
+}
+
+// patternList is a flag.Value collecting regular expressions, compiled
+// as the flags are parsed. The flag may be repeated.
+type patternList []*regexp.Regexp
+
+func (p *patternList) String() string {
+	if p == nil {
+		return ""
+	}
+	exprs := make([]string, len(*p))
+	for i, re := range *p {
+		exprs[i] = re.String()
+	}
+	return strings.Join(exprs, ", ")
+}
+
+func (p *patternList) Set(expr string) error {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+	*p = append(*p, re)
+	return nil
+}
+
+// LinkFilter decides whether the crawler follows a link from parentURL
+// to childURL, where childURL is depth links away from the seed.
+type LinkFilter func(parentURL, childURL string, depth int) bool
+
+// AllLinkFilters returns a LinkFilter passing a link only if every one
+// of filters does. Nil filters are ignored.
+func AllLinkFilters(filters ...LinkFilter) LinkFilter {
+	return func(parentURL, childURL string, depth int) bool {
+		for _, filter := range filters {
+			if filter != nil && !filter(parentURL, childURL, depth) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// LinkFilter returns f as a LinkFilter testing the child URL.
+func (f *PatternFilter) LinkFilter() LinkFilter {
+	return func(_, childURL string, _ int) bool {
+		return f.Allows(childURL)
+	}
+}
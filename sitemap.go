@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const sitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// SitemapEntry is a page listed by WriteSitemapEntries.
+type SitemapEntry struct {
+	URL string
+	// LastModified, unless zero, is written as the page's <lastmod>.
+	LastModified time.Time
+}
+
+// WriteSitemap writes urls to w as a sitemap.xml urlset. Choosing
+// which pages belong in it (typically the successfully fetched pages on
+// the crawled host) is up to the caller.
+func WriteSitemap(w io.Writer, urls []string) error {
+	entries := make([]SitemapEntry, len(urls))
+	for i, url := range urls {
+		entries[i] = SitemapEntry{URL: url}
+	}
+	return WriteSitemapEntries(w, entries)
+}
+
+// WriteSitemapEntries is like WriteSitemap but also gives the pages'
+// modification times.
+func WriteSitemapEntries(w io.Writer, entries []SitemapEntry) error {
+	set := sitemapURLSet{Xmlns: sitemapNamespace}
+	for _, e := range entries {
+		u := sitemapURL{Loc: e.URL}
+		if !e.LastModified.IsZero() {
+			u.LastMod = e.LastModified.UTC().Format(time.RFC3339)
+		}
+		set.URLs = append(set.URLs, u)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(set); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// lastModified returns the time of result's Last-Modified header, or
+// the zero time if there is none or it can't be parsed.
+func lastModified(result CrawlResult) time.Time {
+	t, err := http.ParseTime(result.LastModified)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// sitemapDoc is a sitemap as read by SitemapURLs: either a urlset
+// listing pages or a sitemapindex listing further sitemaps.
+type sitemapDoc struct {
+	XMLName  xml.Name
+	URLs     []sitemapURL `xml:"url"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+// maxSitemapFiles bounds the sitemaps SitemapURLs reads through
+// sitemap indexes.
+const maxSitemapFiles = 1000
+
+// SitemapURLs fetches the sitemap.xml at sitemapURL with fetcher and
+// returns the page URLs it lists, in order and without duplicates, to
+// seed a crawl with. A sitemap index is followed to the sitemaps it
+// lists, and theirs in turn, each read once. Sitemaps that fail to
+// fetch or parse don't stop the others from being read: the URLs found
+// are returned along with all the errors joined.
+func SitemapURLs(ctx context.Context, fetcher Fetcher, sitemapURL string) ([]string, error) {
+	var urls, queue []string
+	var errs []error
+	seen := make(map[string]bool)
+	read := map[string]bool{urlKey(sitemapURL): true}
+	queue = append(queue, sitemapURL)
+	for len(queue) > 0 && len(read) <= maxSitemapFiles {
+		if err := ctx.Err(); err != nil {
+			return urls, err
+		}
+		next := queue[0]
+		queue = queue[1:]
+		page, err := fetchContext(ctx, fetcher, next)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		var doc sitemapDoc
+		if err := xml.Unmarshal([]byte(page.Body), &doc); err != nil {
+			errs = append(errs, fmt.Errorf("sitemap %s: %w", next, err))
+			continue
+		}
+		switch doc.XMLName.Local {
+		case "urlset":
+			for _, u := range doc.URLs {
+				loc := strings.TrimSpace(u.Loc)
+				if loc != "" && !seen[urlKey(loc)] {
+					seen[urlKey(loc)] = true
+					urls = append(urls, loc)
+				}
+			}
+		case "sitemapindex":
+			for _, s := range doc.Sitemaps {
+				loc := strings.TrimSpace(s.Loc)
+				if loc != "" && !read[urlKey(loc)] {
+					read[urlKey(loc)] = true
+					queue = append(queue, loc)
+				}
+			}
+		default:
+			errs = append(errs, fmt.Errorf("sitemap %s: unexpected <%s> element", next, doc.XMLName.Local))
+		}
+	}
+	return urls, errors.Join(errs...)
+}
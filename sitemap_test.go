@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteSitemap(t *testing.T) {
+	var b strings.Builder
+	if err := WriteSitemap(&b, []string{"https://example.com/", "https://example.com/a?x=1&y=2"}); err != nil {
+		t.Fatal(err)
+	}
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url>
+    <loc>https://example.com/</loc>
+  </url>
+  <url>
+    <loc>https://example.com/a?x=1&amp;y=2</loc>
+  </url>
+</urlset>
+`
+	if b.String() != want {
+		t.Errorf("WriteSitemap wrote\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestWriteSitemapEntries(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	var b strings.Builder
+	err := WriteSitemapEntries(&b, []SitemapEntry{
+		{URL: "https://example.com/", LastModified: modified},
+		{URL: "https://example.com/undated"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "<lastmod>2024-05-01T10:00:00Z</lastmod>") {
+		t.Errorf("sitemap lacks the first page's lastmod in UTC:\n%s", b.String())
+	}
+	if n := strings.Count(b.String(), "<lastmod>"); n != 1 {
+		t.Errorf("sitemap has %d lastmods, want 1:\n%s", n, b.String())
+	}
+}
+
+func TestLastModifiedWithoutKeptHeaders(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Last-Modified", "Wed, 01 May 2024 10:00:00 GMT")
+		io.WriteString(w, "<p>home</p>")
+	}))
+	defer srv.Close()
+	f := &HTTPFetcher{KeepHeaders: []string{"Content-Type"}}
+	output := make(chan CrawlResult, 1)
+	c := NewCrawler(Config{Depth: 1, Fetcher: f, Output: output})
+	if err := c.Run(t.Context(), srv.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+	result := <-output
+	if _, kept := result.Headers["Last-Modified"]; kept {
+		t.Fatalf("Last-Modified kept in Headers %v, want it left out", result.Headers)
+	}
+	if lastModified(result).IsZero() {
+		t.Errorf("lastModified of a page served with Last-Modified %q is zero", result.LastModified)
+	}
+}
+
+func TestSitemapURLsIndex(t *testing.T) {
+	index := func(locs ...string) *fakeResult {
+		body := `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`
+		for _, loc := range locs {
+			body += "<sitemap><loc>" + loc + "</loc></sitemap>"
+		}
+		return &fakeResult{body: body + "</sitemapindex>"}
+	}
+	urlset := func(locs ...string) *fakeResult {
+		body := `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`
+		for _, loc := range locs {
+			body += "<url><loc>" + loc + "</loc></url>"
+		}
+		return &fakeResult{body: body + "</urlset>"}
+	}
+	const site = "https://example.com/"
+	fetcher := fakeFetcher{
+		site + "sitemap.xml":       index(site+"pages.xml", site+"nested.xml", site+"missing.xml"),
+		site + "pages.xml":         urlset(site, site+"a"),
+		site + "nested.xml":        index(site+"sitemap.xml", site+"posts.xml", site+"pages.xml"),
+		site + "posts.xml":         urlset(site+"a", site+"posts/1", " "+site+"posts/2 "),
+		site + "not-a-sitemap.xml": {body: "<html></html>"},
+	}
+
+	urls, err := SitemapURLs(context.Background(), fetcher, site+"sitemap.xml")
+	want := []string{site, site + "a", site + "posts/1", site + "posts/2"}
+	if !slices.Equal(urls, want) {
+		t.Errorf("SitemapURLs = %q, want %q", urls, want)
+	}
+	if err == nil || !strings.Contains(err.Error(), "missing.xml") {
+		t.Errorf("SitemapURLs error = %v, want the failure of missing.xml", err)
+	}
+
+	fetcher[site+"sitemap.xml"] = index(site+"pages.xml", site+"not-a-sitemap.xml")
+	urls, err = SitemapURLs(context.Background(), fetcher, site+"sitemap.xml")
+	if want := []string{site, site + "a"}; !slices.Equal(urls, want) {
+		t.Errorf("SitemapURLs = %q, want %q", urls, want)
+	}
+	if err == nil || !strings.Contains(err.Error(), "unexpected <html>") {
+		t.Errorf("SitemapURLs error = %v, want the <html> document rejected", err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrSoft404 is the error of a result flagged by Config.Soft404: the
+// server answered with a success status, but the page says it is
+// missing.
+var ErrSoft404 = errors.New("page not found despite a success status (soft 404)")
+
+// Soft404Detector reports whether a successfully fetched result is
+// really a "not found" page. What gives such pages away is particular
+// to each site, so the Crawler takes any predicate; SimilarBodyDetector
+// is one. It is called from many goroutines at once and must be safe
+// for concurrent use.
+type Soft404Detector func(result CrawlResult) bool
+
+// SimilarBodyDetector returns a Soft404Detector flagging pages whose
+// body is at least threshold similar to notFound, the body of a page
+// known to be missing, such as the one served for a made-up path.
+// Similarity is the Jaccard index of the three-word sequences of the
+// bodies, from 0 for nothing in common to 1 for the same words in the
+// same order, so pages that echo the missing URL back still match.
+func SimilarBodyDetector(notFound string, threshold float64) Soft404Detector {
+	want := shingles(notFound)
+	return func(result CrawlResult) bool {
+		return jaccard(want, shingles(result.Body)) >= threshold
+	}
+}
+
+// shingles returns the sequences of three consecutive words of body,
+// or its words if it has fewer.
+func shingles(body string) map[string]bool {
+	words := strings.Fields(strings.ToLower(body))
+	const n = 3
+	set := make(map[string]bool)
+	if len(words) < n {
+		for _, word := range words {
+			set[word] = true
+		}
+		return set
+	}
+	for i := range len(words) - n + 1 {
+		set[strings.Join(words[i:i+n], " ")] = true
+	}
+	return set
+}
+
+// jaccard returns the size of the intersection of a and b over that of
+// their union, or 1 if both are empty.
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	common := 0
+	for s := range a {
+		if b[s] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(a)+len(b)-common)
+}
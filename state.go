@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// FrontierEntry is a URL waiting to be crawled, found Depth links away
+// from the seed.
+type FrontierEntry struct {
+	URL   string
+	Depth int
+	// Path is the chain of pages from the seed that led to URL; see
+	// CrawlResult.Path.
+	Path []string
+}
+
+// CrawlState is a snapshot of an unfinished breadth-first crawl: the
+// URLs already scheduled, with the depth each was found at, and the
+// frontier still to be fetched. Passing the frontier to Crawler.Resume, with
+// the visited URLs loaded into the VisitedSet, continues the crawl where
+// it stopped.
+type CrawlState struct {
+	Visited  map[string]int
+	Frontier []FrontierEntry
+}
+
+// Save writes the state to path as gob.
+func (s *CrawlState) Save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(s); err != nil {
+		f.Close()
+		return fmt.Errorf("save crawl state %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// LoadCrawlState reads a state written by Save. It returns nil and no
+// error if path does not exist.
+func LoadCrawlState(path string) (*CrawlState, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var s CrawlState
+	if err := gob.NewDecoder(f).Decode(&s); err != nil {
+		return nil, fmt.Errorf("load crawl state %s: %w", path, err)
+	}
+	return &s, nil
+}
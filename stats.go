@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats summarizes a crawl.
+type Stats struct {
+	PagesFetched int64
+	CacheHits    int64
+	CacheMisses  int64
+	Errors       int64
+	// BytesDownloaded is the total size of the fetched page bodies.
+	BytesDownloaded int64
+	// InFlight is the number of fetches in progress, and PeakInFlight
+	// the most there have been at once.
+	InFlight     int64
+	PeakInFlight int64
+	Duration     time.Duration
+	// Fetches is the number of fetches timed, cache hits and failures
+	// included, and FetchTime their total duration. FetchP95 is the
+	// duration under which 95% of them completed, within 10%.
+	Fetches   int64
+	FetchTime time.Duration
+	FetchP95  time.Duration
+	// PagesUnchanged and PagesChanged count the pages of a saved cache
+	// an incremental CacheFetcher found the same and changed when
+	// fetching them again.
+	PagesUnchanged int64
+	PagesChanged   int64
+
+	// The fields below are only filled in for requests sent through
+	// InstrumentTransport or a MetricsFetcher.
+
+	// Requests is the number of HTTP requests sent, redirects included.
+	Requests int64
+	// ResponseBytes is the total size of the response bodies read.
+	ResponseBytes int64
+	// RequestTime is the total latency of the requests.
+	RequestTime time.Duration
+	// StatusCodes counts the responses by HTTP status; requests that
+	// got no response are counted under zero.
+	StatusCodes map[int]int64
+}
+
+func (s Stats) String() string {
+	str := fmt.Sprintf("fetched %d pages (%d bytes), %d cache hits, %d cache misses, %d errors in %s",
+		s.PagesFetched, s.BytesDownloaded, s.CacheHits, s.CacheMisses, s.Errors, s.Duration.Round(time.Millisecond))
+	if s.PeakInFlight > 0 {
+		str += fmt.Sprintf(", at most %d fetches at once", s.PeakInFlight)
+	}
+	if s.Fetches > 0 {
+		avg := s.FetchTime / time.Duration(s.Fetches)
+		str += fmt.Sprintf("; fetches took %s on average, %s at p95", avg.Round(time.Millisecond), s.FetchP95.Round(time.Millisecond))
+	}
+	if s.PagesUnchanged > 0 || s.PagesChanged > 0 {
+		str += fmt.Sprintf("; %d pages unchanged and %d changed since the last crawl", s.PagesUnchanged, s.PagesChanged)
+	}
+	if s.Requests > 0 {
+		avg := s.RequestTime / time.Duration(s.Requests)
+		str += fmt.Sprintf("; %d requests averaging %s", s.Requests, avg.Round(time.Millisecond))
+	}
+	return str
+}
+
+// StatsCollector accumulates Stats while a crawl runs. It is safe for
+// concurrent use; a nil *StatsCollector collects nothing.
+type StatsCollector struct {
+	start  time.Time
+	pages  atomic.Int64
+	hits   atomic.Int64
+	misses atomic.Int64
+	errors atomic.Int64
+	bytes  atomic.Int64
+	active atomic.Int64
+	peak   atomic.Int64
+
+	fetchTime atomic.Int64
+	latencies [latencyBuckets]atomic.Int64
+	unchanged atomic.Int64
+	changed   atomic.Int64
+
+	requests      atomic.Int64
+	responseBytes atomic.Int64
+	requestTime   atomic.Int64
+	statusMux     sync.Mutex
+	statusCodes   map[int]int64
+}
+
+// NewStatsCollector returns a collector whose Duration is measured
+// from now.
+func NewStatsCollector() *StatsCollector {
+	return &StatsCollector{start: time.Now()}
+}
+
+// fetchStarted counts a fetch in progress and returns the number there
+// are now, or zero for a nil collector.
+func (c *StatsCollector) fetchStarted() int64 {
+	if c == nil {
+		return 0
+	}
+	n := c.active.Add(1)
+	for {
+		peak := c.peak.Load()
+		if n <= peak || c.peak.CompareAndSwap(peak, n) {
+			return n
+		}
+	}
+}
+
+func (c *StatsCollector) fetchDone(took time.Duration) {
+	if c == nil {
+		return
+	}
+	c.active.Add(-1)
+	c.fetchTime.Add(int64(took))
+	c.latencies[latencyBucket(took)].Add(1)
+}
+
+// latencyBuckets is the number of buckets fetch durations are counted
+// in, each 10% wider than the one before, from a microsecond to over an
+// hour. Their fixed number bounds the memory the percentiles take.
+const latencyBuckets = 240
+
+// latencyBucket returns the bucket of a fetch taking d.
+func latencyBucket(d time.Duration) int {
+	if d <= time.Microsecond {
+		return 0
+	}
+	i := int(math.Log(float64(d)/float64(time.Microsecond))/math.Log(1.1)) + 1
+	return min(i, latencyBuckets-1)
+}
+
+// latencyBucketMax returns the longest duration counted in bucket i.
+func latencyBucketMax(i int) time.Duration {
+	return time.Duration(float64(time.Microsecond) * math.Pow(1.1, float64(i)))
+}
+
+// percentile returns the duration under which the fraction p of the
+// fetches completed, or zero if there were none, and their number.
+func (c *StatsCollector) percentile(p float64) (time.Duration, int64) {
+	var counts [latencyBuckets]int64
+	var total int64
+	for i := range counts {
+		counts[i] = c.latencies[i].Load()
+		total += counts[i]
+	}
+	rank := int64(math.Ceil(p * float64(total)))
+	var seen int64
+	for i, n := range counts {
+		seen += n
+		if n > 0 && seen >= rank {
+			return latencyBucketMax(i), total
+		}
+	}
+	return 0, total
+}
+
+func (c *StatsCollector) pageFetched(bytes int) {
+	if c != nil {
+		c.pages.Add(1)
+		c.bytes.Add(int64(bytes))
+	}
+}
+
+func (c *StatsCollector) fetchFailed() {
+	if c != nil {
+		c.errors.Add(1)
+	}
+}
+
+func (c *StatsCollector) cacheHit() {
+	if c != nil {
+		c.hits.Add(1)
+	}
+}
+
+func (c *StatsCollector) cacheMiss() {
+	if c != nil {
+		c.misses.Add(1)
+	}
+}
+
+func (c *StatsCollector) pageCompared(unchanged bool) {
+	switch {
+	case c == nil:
+	case unchanged:
+		c.unchanged.Add(1)
+	default:
+		c.changed.Add(1)
+	}
+}
+
+func (c *StatsCollector) requestDone(status int, bytes int64, latency time.Duration) {
+	if c == nil {
+		return
+	}
+	c.requests.Add(1)
+	c.responseBytes.Add(bytes)
+	c.requestTime.Add(int64(latency))
+	c.statusMux.Lock()
+	if c.statusCodes == nil {
+		c.statusCodes = make(map[int]int64)
+	}
+	c.statusCodes[status]++
+	c.statusMux.Unlock()
+}
+
+// Stats returns the counts collected so far.
+func (c *StatsCollector) Stats() Stats {
+	c.statusMux.Lock()
+	statusCodes := maps.Clone(c.statusCodes)
+	c.statusMux.Unlock()
+	p95, fetches := c.percentile(0.95)
+	return Stats{
+		PagesFetched:    c.pages.Load(),
+		CacheHits:       c.hits.Load(),
+		CacheMisses:     c.misses.Load(),
+		Errors:          c.errors.Load(),
+		BytesDownloaded: c.bytes.Load(),
+		InFlight:        c.active.Load(),
+		PeakInFlight:    c.peak.Load(),
+		Duration:        time.Since(c.start),
+		Fetches:         fetches,
+		FetchTime:       time.Duration(c.fetchTime.Load()),
+		FetchP95:        p95,
+		PagesUnchanged:  c.unchanged.Load(),
+		PagesChanged:    c.changed.Load(),
+		Requests:        c.requests.Load(),
+		ResponseBytes:   c.responseBytes.Load(),
+		RequestTime:     time.Duration(c.requestTime.Load()),
+		StatusCodes:     statusCodes,
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// instrumentingTransport is an http.RoundTripper recording every request
+// it passes on in a StatsCollector: its status, the bytes of its
+// response body and its latency, measured until the body is closed.
+type instrumentingTransport struct {
+	next  http.RoundTripper
+	stats *StatsCollector
+}
+
+// InstrumentTransport wraps next, or http.DefaultTransport if next is
+// nil, so that every request is recorded in stats. The crawler knows
+// nothing of it: install it as the Transport of the client passed to
+// NewHTTPFetcher to opt in, as in
+//
+//	client := &http.Client{Transport: InstrumentTransport(nil, stats)}
+//	fetcher := NewHTTPFetcher(client)
+func InstrumentTransport(next http.RoundTripper, stats *StatsCollector) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &instrumentingTransport{next: next, stats: stats}
+}
+
+func (t *instrumentingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		t.stats.requestDone(0, 0, time.Since(start))
+		return nil, err
+	}
+	resp.Body = &countingBody{ReadCloser: resp.Body, done: func(n int64) {
+		t.stats.requestDone(resp.StatusCode, n, time.Since(start))
+	}}
+	return resp, nil
+}
+
+// countingBody counts the bytes read from a response body and reports
+// them to done when the body is closed.
+type countingBody struct {
+	io.ReadCloser
+	n    int64
+	once sync.Once
+	done func(n int64)
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+func (b *countingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.done(b.n) })
+	return err
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInstrumentTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, "hello")
+	}))
+	defer srv.Close()
+
+	stats := NewStatsCollector()
+	client := &http.Client{Transport: InstrumentTransport(srv.Client().Transport, stats)}
+	f := NewHTTPFetcher(client)
+	for _, path := range []string{"/", "/", "/missing"} {
+		f.Fetch(srv.URL + path)
+	}
+
+	s := stats.Stats()
+	if s.Requests != 3 {
+		t.Errorf("Requests = %d, want 3", s.Requests)
+	}
+	if s.StatusCodes[200] != 2 || s.StatusCodes[404] != 1 {
+		t.Errorf("StatusCodes = %v, want 2 of 200 and 1 of 404", s.StatusCodes)
+	}
+	if s.ResponseBytes < 2*int64(len("hello")) {
+		t.Errorf("ResponseBytes = %d, want at least %d", s.ResponseBytes, 2*len("hello"))
+	}
+	if s.RequestTime <= 0 {
+		t.Errorf("RequestTime = %s, want it positive", s.RequestTime)
+	}
+}
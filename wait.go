@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// Start runs Run(ctx, seeds...) in the background; Wait waits for it to
+// finish. Results still go to Config.Output, which must be read.
+func (c *Crawler) Start(ctx context.Context, seeds ...string) {
+	done := make(chan struct{})
+	c.waitMux.Lock()
+	c.done = done
+	c.runErr = nil
+	c.waitMux.Unlock()
+	go func() {
+		defer close(done)
+		err := c.Run(ctx, seeds...)
+		c.waitMux.Lock()
+		c.runErr = err
+		c.waitMux.Unlock()
+	}()
+}
+
+// Wait blocks until the crawl begun by the last Start has finished, and
+// returns nil if it ran to the end and every fetch succeeded. Otherwise
+// it returns the errors joined with errors.Join: first the one Run
+// returned, if any, then those of the failed fetches in the order they
+// failed, as they were reported in results. After a Run, Resume or
+// Discover called directly Wait returns at once, with the failures of
+// that crawl.
+func (c *Crawler) Wait() error {
+	c.waitMux.Lock()
+	done := c.done
+	c.waitMux.Unlock()
+	if done != nil {
+		<-done
+	}
+	c.waitMux.Lock()
+	defer c.waitMux.Unlock()
+	return errors.Join(append([]error{c.runErr}, c.failures...)...)
+}
+
+// resetFailures forgets the failures of the previous crawl.
+func (c *Crawler) resetFailures() {
+	c.waitMux.Lock()
+	c.failures = nil
+	c.waitMux.Unlock()
+}
+
+// addFailure records the error of a failed fetch for Wait.
+func (c *Crawler) addFailure(err error) {
+	c.waitMux.Lock()
+	c.failures = append(c.failures, err)
+	c.waitMux.Unlock()
+}